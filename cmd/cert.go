@@ -0,0 +1,113 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	gotris "github.com/matchstick/gotris/lib"
+)
+
+func newCertCmd() *cobra.Command {
+	const numCertCmdArgs = 0
+	var certPath string
+	var keyPath string
+	var hosts []string
+	var days int
+	var force bool
+
+	certCmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Generate a self-signed TLS certificate.",
+		Long: `Generates a self-signed certificate and key for serving gotris over https
+and wss on a LAN. Pass them to start with --tls-cert and --tls-key. Browsers
+will warn about the certificate until it is trusted.`,
+		Args: cobra.MaximumNArgs(numCertCmdArgs),
+		Run: func(cmd *cobra.Command, args []string) {
+			if days < 1 {
+				fmt.Fprintf(os.Stderr, "Certificate must be valid for at least a day not %d\n", days)
+				os.Exit(1)
+			}
+
+			if len(hosts) == 0 {
+				hosts = gotris.DefaultCertHosts()
+			}
+
+			certPEM, keyPEM, err := gotris.GenerateSelfSignedCert(hosts, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = writeNewFile(certPath, certPEM, 0644, force)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = writeNewFile(keyPath, keyPEM, 0600, force)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Wrote %s and %s for %v\n", certPath, keyPath, hosts)
+		},
+	}
+
+	certCmd.Flags().StringVar(&certPath, "cert", "gotris.crt", "Where to write the certificate")
+	certCmd.Flags().StringVar(&keyPath, "key", "gotris.key", "Where to write the private key")
+	certCmd.Flags().StringSliceVar(&hosts, "host", nil, "Host names and IPs to cover (default this machine and localhost)")
+	certCmd.Flags().IntVar(&days, "days", 365, "Days the certificate is valid")
+	certCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite existing files")
+
+	return certCmd
+}
+
+// writeNewFile writes data to path, refusing to clobber an existing file
+// unless force is set.
+func writeNewFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,98 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	gotris "github.com/matchstick/gotris/lib"
+)
+
+func newLeaderboardCmd() *cobra.Command {
+	const numLeaderboardCmdArgs = 0
+	var mode string
+	var limit int
+	var keep int
+
+	leaderboardCmd := &cobra.Command{
+		Use:   "leaderboard",
+		Short: "Print or prune the high score leaderboard.",
+		Long: `Prints the best scores from the leaderboard file. With --prune only the
+best <keep> entries of each mode, 100 for a bare --prune, are kept and the rest are
+deleted. Pruning is safe while a server is recording games to the same file.`,
+		Args:    cobra.MaximumNArgs(numLeaderboardCmdArgs),
+		PreRunE: bindFlags,
+		Run: func(cmd *cobra.Command, args []string) {
+			lb, err := gotris.OpenLeaderboard(viper.GetString("leaderboard"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if cmd.Flags().Changed("prune") {
+				dropped, err := lb.Prune(keep)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				fmt.Printf("Pruned %d entries\n", dropped)
+			}
+
+			printLeaderboard(lb.Top(gotris.GameMode(mode), limit))
+		},
+	}
+
+	leaderboardCmd.Flags().StringP("leaderboard", "l", defaultLeaderboardPath(), "Leaderboard file")
+	leaderboardCmd.Flags().StringVarP(&mode, "mode", "m", "", "Only show this game mode")
+	leaderboardCmd.Flags().IntVarP(&limit, "limit", "n", 10, "Number of entries to show, 0 for all")
+	leaderboardCmd.Flags().IntVar(&keep, "prune", 100, "Keep only this many entries per mode, as --prune=<keep>")
+	leaderboardCmd.Flags().Lookup("prune").NoOptDefVal = "100"
+
+	return leaderboardCmd
+}
+
+func printLeaderboard(entries []gotris.ScoreEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No scores recorded yet.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tNAME\tMODE\tSCORE\tLINES\tLEVEL\tTIME\tSEED\tDATE\tFLAGGED")
+	for i, e := range entries {
+		duration := (time.Duration(e.DurationMS) * time.Millisecond).Round(time.Second)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%s\t%d\t%s\t%s\n", i+1, e.Name, e.Mode,
+			e.Score, e.Lines, e.Level, duration, e.Seed, e.FinishedAt.Format(time.DateTime), e.Flagged)
+	}
+	w.Flush()
+}
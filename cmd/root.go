@@ -27,9 +27,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"net"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -62,42 +65,89 @@ func isPortLegit(port int) error {
 		return fmt.Errorf("Port must be between %d and %d not %d\n", minPortNum, maxPortNum, port)
 	}
 
-	address := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", address)
+	// Whether the port is free is found out by the server when it listens
+	return nil
+}
+
+// defaultLeaderboardPath keeps the leaderboard next to the config file in
+// the home directory.
+func defaultLeaderboardPath() string {
+	const leaderboardFilename string = ".gotris_leaderboard.json"
 
+	home, err := homedir.Dir()
 	if err != nil {
-		// Port is in use or otherwise unavailable
-		return fmt.Errorf("Port %d is in use\n", port)
+		return leaderboardFilename
 	}
-
-	// Don't forget to close the listener when done
-	defer listener.Close()
-
-	return nil
-
+	return filepath.Join(home, leaderboardFilename)
 }
 
 func newStartCmd() *cobra.Command {
-	var port int
-	var numberOfPlayers int
-
 	startCmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start up the server for gotris.",
-		Long:  `Starts up the server and requires the arguments <port> and <# sessions>`,
+		Long: `Starts up the server and requires the arguments <port> and <# sessions>
+
+Every option can also be set in the config file or with a GOTRIS_ environment
+variable, --reconnect-grace becomes GOTRIS_RECONNECT_GRACE for example.
+Flags win over environment variables which win over the config file.`,
+		PreRunE: bindFlags,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := isPortLegit(port)
+			err := setupLogging(viper.GetString("log-format"), viper.GetString("log-level"))
 			if err != nil {
-				fmt.Println(err)
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
-			if numberOfPlayers < 1 || numberOfPlayers > 1 {
-				fmt.Fprintf(os.Stderr, "Only one player supported now not %d\n", numberOfPlayers)
+			bind := viper.GetString("bind")
+			port := viper.GetInt("port")
+			numberOfPlayers := viper.GetInt("players")
+
+			// Unix sockets have no port
+			if !strings.HasPrefix(bind, "unix:") {
+				err := isPortLegit(port)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+
+			mode, err := gotris.ParseGameMode(viper.GetString("mode"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
-			err = gotris.NewServer(port, numberOfPlayers)
+			cfg := gotris.ServerConfig{
+				Bind:              bind,
+				Port:              port,
+				NumPlayers:        numberOfPlayers,
+				LeaderboardPath:   viper.GetString("leaderboard"),
+				ReconnectGrace:    viper.GetDuration("reconnect-grace"),
+				IdleTimeout:       viper.GetDuration("idle-timeout"),
+				DuplicateSessions: gotris.DuplicatePolicy(viper.GetString("duplicate-sessions")),
+				DrainTimeout:      viper.GetDuration("drain-timeout"),
+				StaticDir:         viper.GetString("static-dir"),
+				TLSCert:           viper.GetString("tls-cert"),
+				TLSKey:            viper.GetString("tls-key"),
+				AllowedOrigins:    splitList(viper.GetStringSlice("allowed-origins")),
+				MaxSessions:       viper.GetInt("max-sessions"),
+				MaxConnsPerIP:     viper.GetInt("max-conns-per-ip"),
+				MaxMessageSize:    viper.GetInt64("max-message-size"),
+				ReadTimeout:       viper.GetDuration("read-timeout"),
+				PingInterval:      viper.GetDuration("ping-interval"),
+				AdminToken:        viper.GetString("admin-token"),
+				Rules: gotris.GameRules{
+					Mode:      mode,
+					Gravity:   viper.GetDuration("gravity"),
+					LockDelay: viper.GetDuration("lock-delay"),
+					InputRate: viper.GetInt("input-rate"),
+				},
+				MatchRating:   viper.GetBool("match-rating"),
+				MatchBotAfter: viper.GetDuration("match-bot-after"),
+				AttackTables:  viper.GetString("attack-tables"),
+			}
+
+			err = gotris.NewServer(cfg)
 			if err != nil {
 				fmt.Printf("NewServer failed. Error %s\n", err)
 				os.Exit(1)
@@ -105,12 +155,81 @@ func newStartCmd() *cobra.Command {
 		},
 	}
 
-	startCmd.Flags().IntVarP(&port, "port", "p", 8080, "Port to Listen on")
-	startCmd.Flags().IntVarP(&numberOfPlayers, "players", "n", 1, "Number of Players")
+	startCmd.Flags().IntP("port", "p", 8080, "Port to Listen on")
+	startCmd.Flags().StringP("bind", "b", "", "Address to listen on, an IP, host name or unix:<path>")
+	startCmd.Flags().IntP("players", "n", gotris.DefaultRoomPlayers, "How many players fit in a new room")
+	startCmd.Flags().StringP("mode", "m", string(gotris.Marathon), "Game mode")
+	startCmd.Flags().Duration("gravity", gotris.DefaultGravity, "How long a piece takes to fall one row at level 1")
+	startCmd.Flags().Duration("lock-delay", gotris.DefaultLockDelay, "How long a piece on the stack can move before it locks")
+	startCmd.Flags().Int("input-rate", gotris.DefaultInputRate, "Most inputs a second a player may send, 0 for no limit")
+	startCmd.Flags().String("static-dir", "", "Serve the web client from this directory instead of the built in copy")
+	startCmd.Flags().String("tls-cert", "", "TLS certificate file, serves https and wss")
+	startCmd.Flags().String("tls-key", "", "TLS private key file")
+	startCmd.Flags().StringSlice("allowed-origins", nil, "Browser origins allowed to connect, * for any (default same origin)")
+	startCmd.Flags().Int("max-sessions", gotris.DefaultMaxSessions, "Most sessions the server keeps at once, 0 for no cap")
+	startCmd.Flags().Int("max-conns-per-ip", gotris.DefaultMaxConnsPerIP, "Most open connections and sessions from one address, 0 for no cap")
+	startCmd.Flags().Int64("max-message-size", gotris.DefaultMaxMessageSize, "Largest message in bytes a client may send")
+	startCmd.Flags().Duration("read-timeout", gotris.DefaultReadTimeout, "Drop clients that send nothing, not even a pong, for this long")
+	startCmd.Flags().Duration("ping-interval", gotris.DefaultPingInterval, "How often clients are pinged, 0 never")
+	startCmd.Flags().String("admin-token", "", "Bearer token for the /admin endpoints, empty turns them off")
+	startCmd.Flags().Bool("match-rating", false, "Match players in the matchmaking queue by rating")
+	startCmd.Flags().Duration("match-bot-after", gotris.DefaultMatchBotAfter, "Fill a match with bots after this long in the queue, 0 never")
+	startCmd.Flags().String("attack-tables", "", "JSON file with garbage rules for rooms to pick from")
+	startCmd.Flags().Duration("reconnect-grace", gotris.DefaultReconnectGrace, "How long a dropped game waits for its player")
+	startCmd.Flags().Duration("idle-timeout", gotris.DefaultIdleTimeout, "Disconnect players who send nothing for this long")
+	startCmd.Flags().String("duplicate-sessions", string(gotris.TakeoverDuplicates), "What to do when a session connects twice: takeover or reject")
+	startCmd.Flags().Duration("drain-timeout", gotris.DefaultDrainTimeout, "How long games get to finish on shutdown")
+	startCmd.Flags().StringP("leaderboard", "l", defaultLeaderboardPath(), "Leaderboard file, empty disables it")
+	startCmd.Flags().String("log-format", "text", "Log format: text or json")
+	startCmd.Flags().String("log-level", "info", "Least severe level logged: debug, info, warn or error")
 
 	return startCmd
 }
 
+// setupLogging sends the server's logs to stdout in the given format,
+// dropping anything below level.
+func setupLogging(format string, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// splitList accepts lists written with commas or spaces. Flags split on
+// commas while viper splits environment variables on spaces.
+func splitList(items []string) []string {
+	var list []string
+	for _, item := range items {
+		for _, s := range strings.Split(item, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// bindFlags makes the flags of the command being run the viper keys of the
+// same name. It is done when the command runs, not when it is built, because
+// commands share flag names and viper only keeps one binding per key.
+func bindFlags(cmd *cobra.Command, args []string) error {
+	return viper.BindPFlags(cmd.Flags())
+}
+
 func rootCmd() *cobra.Command {
 
 	// rootCmd represents the base command when called without any subcommands.
@@ -128,9 +247,12 @@ func Execute() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd := rootCmd()
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "Config file (default $HOME/.gotris.yaml)")
 
 	rootCmd.AddCommand(newStartCmd())
 	rootCmd.AddCommand(versionCmd())
+	rootCmd.AddCommand(newLeaderboardCmd())
+	rootCmd.AddCommand(newCertCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -138,10 +260,14 @@ func Execute() {
 	}
 }
 
+// cfgFile is set by the --config flag
+var cfgFile string
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	const cfgFilename string = ".gotris"
-	var cfgFile string
+	const envPrefix string = "gotris"
+
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
@@ -159,10 +285,23 @@ func initConfig() {
 		viper.SetConfigName(cfgFilename)
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// Read in environment variables that match, GOTRIS_DRAIN_TIMEOUT for the
+	// drain-timeout key
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
+	// If a config file is found, read it in. One given with --config has to
+	// be there.
+	err := viper.ReadInConfig()
+	if err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		return
+	}
+
+	var notFound viper.ConfigFileNotFoundError
+	if cfgFile != "" || !errors.As(err, &notFound) {
+		fmt.Fprintf(os.Stderr, "Failed to read config: %v\n", err)
+		os.Exit(1)
 	}
 }
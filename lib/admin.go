@@ -0,0 +1,162 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// adminToken guards the /admin endpoints, which are turned off while it is
+// empty
+var adminToken string
+
+// SessionStatus describes a session on the /admin/sessions endpoint
+type SessionStatus struct {
+	ID        string   `json:"id"`
+	Player    string   `json:"player"`
+	Mode      GameMode `json:"mode"`
+	Score     int      `json:"score"`
+	Level     int      `json:"level"`
+	Lines     int      `json:"lines"`
+	GameOver  bool     `json:"game_over"`
+	Flagged   string   `json:"flagged,omitempty"`
+	Connected bool     `json:"connected"`
+	Remote    string   `json:"remote"` // the last address the session connected from
+	Uptime    string   `json:"uptime"`
+	Idle      string   `json:"idle"`
+}
+
+// handleHealthz answers as long as the server is up
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz tells load balancers whether the server takes new players. It
+// does not while shutting down or when the session cap has been reached.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if registry.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	registered, _ := registry.counts()
+	if limits.maxSessions > 0 && registered >= limits.maxSessions {
+		http.Error(w, "server is full", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// requireAdmin wraps an admin handler with a check of the bearer token
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			slog.Warn("admin request denied", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gotris"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminSessions lists every session, oldest first
+func handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := registry.snapshot()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].created.Before(sessions[j].created)
+	})
+
+	list := make([]SessionStatus, 0, len(sessions))
+	for _, s := range sessions {
+		status, ok := registry.status(s)
+		if ok {
+			list = append(list, status)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		slog.Error("failed to encode sessions", "err", err)
+	}
+}
+
+// handleAdminKick disconnects a session and ends its game
+func handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !registry.kick(id) {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	slog.Info("session kicked", "session", id, "by", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// status collects what the admin endpoint shows about s. It returns false
+// if the session ended in the meantime.
+func (m *sessionManager) status(s *session) (SessionStatus, bool) {
+	m.mutex.RLock()
+	connected := s.conn != nil
+	remote := s.remote
+	m.mutex.RUnlock()
+
+	var status SessionStatus
+	ok := s.game.do(func() {
+		status = SessionStatus{
+			Player:   s.game.name,
+			Mode:     s.game.mode,
+			Score:    s.game.state.Score,
+			Level:    s.game.state.Level,
+			Lines:    s.game.state.LinesCleared,
+			GameOver: s.game.state.GameOver,
+			Flagged:  s.game.guard.flag,
+		}
+	})
+	if !ok {
+		return status, false
+	}
+
+	status.ID = s.id
+	status.Connected = connected
+	status.Remote = remote
+	status.Uptime = time.Since(s.created).Round(time.Second).String()
+	status.Idle = s.game.IdleFor().Round(time.Second).String()
+	return status, true
+}
@@ -0,0 +1,170 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DefaultInputRate is how many inputs a second a player may send. Holding a
+// key down repeats at about 30 a second so people never get near it.
+const DefaultInputRate = 40
+
+// A game gets flagged once it has thrown away this many seconds worth of
+// inputs for coming in too fast
+const floodFlagSeconds = 3
+
+// Move patterns nobody at a keyboard produces. Holding a key repeats the
+// same move, so a change of direction is always a new key press.
+const (
+	// regularWindow direction changes in a row whose gaps are all within
+	// regularJitter of each other are a macro, people are never that steady
+	regularWindow = 20
+	regularJitter = 3 * time.Millisecond
+
+	// Keeping up sustainedShare of the input rate for sustainedAfter is a
+	// script, people pause between pieces
+	sustainedShare = 0.75
+	sustainedAfter = time.Minute
+
+	// maxPieceSwitches is the most direction changes a person can fit into
+	// the life of one piece, moves after that are dropped
+	maxPieceSwitches = 40
+)
+
+// inputGuard rate limits a player's inputs with a token bucket and keeps
+// track of anything a person at a keyboard could not have sent. It belongs
+// to the game goroutine.
+type inputGuard struct {
+	rate   float64 // tokens added per second, also the bucket size
+	tokens float64
+	last   time.Time
+
+	dropped int    // inputs thrown away for coming in too fast
+	flag    string // why the game looks scripted, empty if it does not
+
+	first      time.Time       // when the game's first move came in
+	moves      int             // moves this game
+	lastDir    Direction       // the last move
+	lastSwitch time.Time       // when the direction last changed
+	gaps       []time.Duration // between the latest direction changes
+	switches   int             // direction changes for the current piece
+}
+
+func newInputGuard(rate int) inputGuard {
+	return inputGuard{
+		rate:   float64(rate),
+		tokens: float64(rate),
+	}
+}
+
+// reset starts a new game with a full bucket and a clean record
+func (ig *inputGuard) reset() {
+	*ig = newInputGuard(int(ig.rate))
+}
+
+// allow reports whether an input arriving at now fits within the rate
+func (ig *inputGuard) allow(now time.Time) bool {
+	if ig.rate <= 0 {
+		return true
+	}
+
+	if !ig.last.IsZero() {
+		ig.tokens += now.Sub(ig.last).Seconds() * ig.rate
+		ig.tokens = min(ig.tokens, ig.rate)
+	}
+	ig.last = now
+
+	if ig.tokens < 1 {
+		ig.dropped++
+		if ig.dropped >= floodFlagSeconds*int(ig.rate) {
+			ig.raise(fmt.Sprintf("more than %d inputs a second", int(ig.rate)))
+		}
+		return false
+	}
+
+	ig.tokens--
+	return true
+}
+
+// checkMove rejects moves the client cannot produce or a person could not
+// have sent, and flags moves arriving in patterns only a script keeps up
+func (ig *inputGuard) checkMove(dir Direction, now time.Time) bool {
+	if dir < Left || dir > HardDrop {
+		ig.raise(fmt.Sprintf("invalid move %d", dir))
+		return false
+	}
+
+	ig.moves++
+	if ig.first.IsZero() {
+		ig.first = now
+	}
+	elapsed := now.Sub(ig.first)
+	if ig.rate > 0 && elapsed >= sustainedAfter && float64(ig.moves)/elapsed.Seconds() >= sustainedShare*ig.rate {
+		ig.raise(fmt.Sprintf("%.0f moves a second for %s", float64(ig.moves)/elapsed.Seconds(), elapsed.Round(time.Second)))
+	}
+
+	if ig.moves > 1 && dir != ig.lastDir {
+		ig.switches++
+		if !ig.lastSwitch.IsZero() {
+			ig.gaps = append(ig.gaps, now.Sub(ig.lastSwitch))
+			if len(ig.gaps) > regularWindow {
+				ig.gaps = ig.gaps[1:]
+			}
+			if len(ig.gaps) == regularWindow && steady(ig.gaps) {
+				ig.raise(fmt.Sprintf("%d key presses %s apart", regularWindow, ig.gaps[0].Round(time.Millisecond)))
+			}
+		}
+		ig.lastSwitch = now
+	}
+	ig.lastDir = dir
+
+	if ig.switches > maxPieceSwitches {
+		ig.raise(fmt.Sprintf("more than %d direction changes for one piece", maxPieceSwitches))
+		return false
+	}
+	return true
+}
+
+// newPiece starts counting the moves of the next piece
+func (ig *inputGuard) newPiece() {
+	ig.switches = 0
+}
+
+// steady reports whether the gaps are all within regularJitter of each other
+func steady(gaps []time.Duration) bool {
+	return slices.Max(gaps)-slices.Min(gaps) <= regularJitter
+}
+
+// raise flags the game, keeping the first reason given
+func (ig *inputGuard) raise(reason string) {
+	if ig.flag == "" {
+		ig.flag = reason
+	}
+}
@@ -0,0 +1,158 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInputGuardAllow(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        int
+		inputs      int
+		gap         time.Duration
+		wantAllowed int
+		wantFlag    bool
+	}{
+		{"no limit", 0, 1000, 0, 1000, false},
+		{"steady under the rate", 10, 50, 100 * time.Millisecond, 50, false},
+		{"burst", 10, 15, 0, 10, false},
+		{"short flood", 10, 35, 0, 10, false},
+		{"flood", 10, 100, 0, 10, true},
+		{"over the rate for a while", 10, 40, 62500 * time.Microsecond, 34, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ig := newInputGuard(tt.rate)
+			now := time.Now()
+			allowed := 0
+			for range tt.inputs {
+				if ig.allow(now) {
+					allowed++
+				}
+				now = now.Add(tt.gap)
+			}
+
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d inputs, want %d", allowed, tt.wantAllowed)
+			}
+			if (ig.flag != "") != tt.wantFlag {
+				t.Errorf("flag %q, want flagged %v", ig.flag, tt.wantFlag)
+			}
+		})
+	}
+}
+
+// Move patterns for TestInputGuardCheckMove
+var (
+	alternate = func(i int) Direction { return Direction(i % 2) }
+	holdLeft  = func(i int) Direction { return Left }
+
+	// uneven is how far apart a person's key presses are
+	uneven = func(i int) time.Duration { return time.Duration(40+i*37%100) * time.Millisecond }
+	every  = func(gap time.Duration) func(int) time.Duration {
+		return func(int) time.Duration { return gap }
+	}
+)
+
+func TestInputGuardCheckMove(t *testing.T) {
+	tests := []struct {
+		name         string
+		rate         int
+		moves        int
+		dir          func(i int) Direction
+		gap          func(i int) time.Duration
+		pieceEvery   int // moves per piece, 0 for one piece
+		wantRejected int
+		wantFlag     bool
+	}{
+		{"person", DefaultInputRate, 200, alternate, uneven, 20, 0, false},
+		{"held key", DefaultInputRate, 200, holdLeft, every(33 * time.Millisecond), 20, 0, false},
+		{"macro", DefaultInputRate, 30, alternate, every(50 * time.Millisecond), 10, 0, true},
+		{"macro with jitter", DefaultInputRate, 30, alternate, func(i int) time.Duration {
+			return time.Duration(50+i%3) * time.Millisecond
+		}, 10, 0, true},
+		{"too uneven for a macro", DefaultInputRate, 30, alternate, func(i int) time.Duration {
+			return time.Duration(50+i%2*10) * time.Millisecond
+		}, 10, 0, false},
+		{"invalid move", DefaultInputRate, 1, func(int) Direction { return HardDrop + 1 }, uneven, 0, 1, true},
+		{"too many switches for a piece", DefaultInputRate, 45, alternate, uneven, 0, 45 - 1 - maxPieceSwitches, true},
+		{"switches count per piece", DefaultInputRate, 200, alternate, uneven, maxPieceSwitches, 0, false},
+		{"sustained", 10, 500, holdLeft, every(125 * time.Millisecond), 20, 0, true},
+		{"sustained but slow enough", 10, 500, holdLeft, every(200 * time.Millisecond), 20, 0, false},
+		{"sustained without a limit", 0, 500, holdLeft, every(125 * time.Millisecond), 20, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ig := newInputGuard(tt.rate)
+			now := time.Now()
+			rejected := 0
+			for i := range tt.moves {
+				if tt.pieceEvery > 0 && i%tt.pieceEvery == 0 {
+					ig.newPiece()
+				}
+				if !ig.checkMove(tt.dir(i), now) {
+					rejected++
+				}
+				now = now.Add(tt.gap(i))
+			}
+
+			if rejected != tt.wantRejected {
+				t.Errorf("rejected %d moves, want %d", rejected, tt.wantRejected)
+			}
+			if (ig.flag != "") != tt.wantFlag {
+				t.Errorf("flag %q, want flagged %v", ig.flag, tt.wantFlag)
+			}
+		})
+	}
+}
+
+func TestSteady(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		gaps []time.Duration
+		want bool
+	}{
+		{"same", []time.Duration{50 * ms, 50 * ms, 50 * ms}, true},
+		{"within jitter", []time.Duration{50 * ms, 53 * ms, 51 * ms}, true},
+		{"past jitter", []time.Duration{50 * ms, 54 * ms, 51 * ms}, false},
+		{"one", []time.Duration{80 * ms}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := steady(tt.gaps); got != tt.want {
+				t.Errorf("steady = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Clients with the binary capability get every message after the server's
+// hello as a binary websocket frame: a byte with the message type followed
+// by the packed payload. Game states are packed by hand with the board as
+// one nibble per cell, messages without a packed form carry their JSON.
+// Clients may send binary frames the same way. See PROTOCOL.md.
+
+// CapBinary is the capability for binary frames
+const CapBinary = "binary"
+
+// Message type bytes of binary frames
+const (
+	binStateUpdate byte = 0x01
+	binSnapshot    byte = 0x02
+	binDelta       byte = 0x03
+	binMove        byte = 0x10
+	binNewGame     byte = 0x11
+	binResync      byte = 0x12
+	binJSON        byte = 0x7f // the body is a JSON Message
+)
+
+// Bits of the field mask at the start of a packed delta, in the order the
+// fields follow it. The mask is a byte before versionGarbage and a uvarint
+// since.
+const (
+	deltaPiece uint64 = 1 << iota
+	deltaNextPiece
+	deltaScore
+	deltaLevel
+	deltaLines
+	deltaGameOver
+	deltaRows
+	deltaAck
+	deltaGarbage
+)
+
+// packedRowLen is the size of a board row at a nibble per cell
+const packedRowLen = (BoardWidth + 1) / 2
+
+var errShortFrame = errors.New("binary frame ends too early")
+
+// encodeBinary packs a message into a binary frame for a client speaking
+// the given protocol version
+func encodeBinary(version int, msgType MessageType, payload any) ([]byte, error) {
+	switch p := payload.(type) {
+	case GameState:
+		if msgType == StateUpdate {
+			return appendState([]byte{binStateUpdate}, &p, version), nil
+		}
+	case ackedState:
+		frame := appendState([]byte{binStateUpdate}, &p.GameState, version)
+		return appendAck(frame, p.Ack), nil
+	case StateSnapshot:
+		frame := binary.AppendUvarint([]byte{binSnapshot}, p.Seq)
+		frame = appendState(frame, &p.State, version)
+		return appendAck(frame, p.Ack), nil
+	case StateDelta:
+		return appendDelta([]byte{binDelta}, &p, version), nil
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	msgJSON, err := json.Marshal(Message{Type: msgType, Payload: payloadJSON})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{binJSON}, msgJSON...), nil
+}
+
+// appendState packs the whole state: the current piece, next piece, score,
+// level, lines, game over, incoming garbage from versionGarbage on and the
+// board
+func appendState(b []byte, s *GameState, version int) []byte {
+	b = appendPiece(b, s.CurrentPiece)
+	b = append(b, byte(s.NextPiece))
+	b = binary.AppendUvarint(b, uint64(s.Score))
+	b = binary.AppendUvarint(b, uint64(s.Level))
+	b = binary.AppendUvarint(b, uint64(s.LinesCleared))
+	b = appendBool(b, s.GameOver)
+	if version >= versionGarbage {
+		b = binary.AppendUvarint(b, uint64(s.IncomingGarbage))
+	}
+	for y := range s.Board {
+		b = appendRow(b, &s.Board[y])
+	}
+	return b
+}
+
+// appendDelta packs a delta as its sequence number, a mask of the fields
+// present and then those fields
+func appendDelta(b []byte, d *StateDelta, version int) []byte {
+	b = binary.AppendUvarint(b, d.Seq)
+
+	var mask uint64
+	if d.CurrentPiece != nil {
+		mask |= deltaPiece
+	}
+	if d.NextPiece != nil {
+		mask |= deltaNextPiece
+	}
+	if d.Score != nil {
+		mask |= deltaScore
+	}
+	if d.Level != nil {
+		mask |= deltaLevel
+	}
+	if d.LinesCleared != nil {
+		mask |= deltaLines
+	}
+	if d.GameOver != nil {
+		mask |= deltaGameOver
+	}
+	if len(d.Rows) > 0 {
+		mask |= deltaRows
+	}
+	if d.Ack != nil {
+		mask |= deltaAck
+	}
+	if d.Garbage != nil && version >= versionGarbage {
+		mask |= deltaGarbage
+	}
+	if version >= versionGarbage {
+		b = binary.AppendUvarint(b, mask)
+	} else {
+		b = append(b, byte(mask))
+	}
+
+	if d.CurrentPiece != nil {
+		b = appendPiece(b, *d.CurrentPiece)
+	}
+	if d.NextPiece != nil {
+		b = append(b, byte(*d.NextPiece))
+	}
+	if d.Score != nil {
+		b = binary.AppendUvarint(b, uint64(*d.Score))
+	}
+	if d.Level != nil {
+		b = binary.AppendUvarint(b, uint64(*d.Level))
+	}
+	if d.LinesCleared != nil {
+		b = binary.AppendUvarint(b, uint64(*d.LinesCleared))
+	}
+	if d.GameOver != nil {
+		b = appendBool(b, *d.GameOver)
+	}
+	if len(d.Rows) > 0 {
+		b = append(b, byte(len(d.Rows)))
+		for i := range d.Rows {
+			b = append(b, byte(d.Rows[i].Y))
+			b = appendRow(b, &d.Rows[i].Cells)
+		}
+	}
+	if d.Ack != nil {
+		b = binary.AppendUvarint(b, *d.Ack)
+	}
+	if mask&deltaGarbage != 0 {
+		b = binary.AppendUvarint(b, uint64(*d.Garbage))
+	}
+	return b
+}
+
+// appendAck adds the ack that trails a packed state, left out when it is 0
+func appendAck(b []byte, ack uint64) []byte {
+	if ack == 0 {
+		return b
+	}
+	return binary.AppendUvarint(b, ack)
+}
+
+// appendPiece packs a piece as its type, x and y as signed bytes and its
+// rotation
+func appendPiece(b []byte, t Tetromino) []byte {
+	return append(b, byte(t.Type), byte(int8(t.X)), byte(int8(t.Y)), byte(t.Rotation))
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// appendRow packs a board row two cells to a byte, the left cell in the
+// high nibble
+func appendRow(b []byte, row *[BoardWidth]int) []byte {
+	for x := 0; x < BoardWidth; x += 2 {
+		cell := byte(row[x]&0x0f) << 4
+		if x+1 < BoardWidth {
+			cell |= byte(row[x+1] & 0x0f)
+		}
+		b = append(b, cell)
+	}
+	return b
+}
+
+// decodeBinary unpacks a binary frame from a client into the Message its
+// JSON form would have been
+func decodeBinary(frame []byte) (Message, error) {
+	if len(frame) == 0 {
+		return Message{}, errShortFrame
+	}
+
+	body := frame[1:]
+	switch frame[0] {
+	case binMove:
+		// The direction, then the sequence number if there is one
+		if len(body) == 0 {
+			return Message{}, errShortFrame
+		}
+		move := MoveInput{Dir: Direction(body[0])}
+		if len(body) > 1 {
+			seq, n := binary.Uvarint(body[1:])
+			if n <= 0 || n != len(body)-1 {
+				return Message{}, fmt.Errorf("bad sequence number in binary move")
+			}
+			move.Seq = seq
+		}
+		payload, _ := json.Marshal(move)
+		return Message{Type: Move, Payload: payload}, nil
+
+	case binNewGame:
+		return Message{Type: NewGame}, nil
+
+	case binResync:
+		return Message{Type: Resync}, nil
+
+	case binJSON:
+		var msg Message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return Message{}, fmt.Errorf("binary frame holds bad JSON: %w", err)
+		}
+		return msg, nil
+	}
+	return Message{}, fmt.Errorf("unknown binary message type 0x%02x", frame[0])
+}
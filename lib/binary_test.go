@@ -0,0 +1,361 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// frameReader unpacks server frames the way a client does, decodeBinary
+// only knows the client's messages
+type frameReader struct {
+	b   []byte
+	bad bool
+}
+
+func (r *frameReader) byte() byte {
+	if len(r.b) == 0 {
+		r.bad = true
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *frameReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.bad = true
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *frameReader) piece() Tetromino {
+	return Tetromino{
+		Type:     TetrominoType(r.byte()),
+		X:        int(int8(r.byte())),
+		Y:        int(int8(r.byte())),
+		Rotation: int(r.byte()),
+	}
+}
+
+func (r *frameReader) row() [BoardWidth]int {
+	var row [BoardWidth]int
+	for x := 0; x < BoardWidth; x += 2 {
+		cell := r.byte()
+		row[x] = int(cell >> 4)
+		if x+1 < BoardWidth {
+			row[x+1] = int(cell & 0x0f)
+		}
+	}
+	return row
+}
+
+func (r *frameReader) state(version int) GameState {
+	var s GameState
+	s.CurrentPiece = r.piece()
+	s.NextPiece = TetrominoType(r.byte())
+	s.Score = int(r.uvarint())
+	s.Level = int(r.uvarint())
+	s.LinesCleared = int(r.uvarint())
+	s.GameOver = r.byte() == 1
+	if version >= versionGarbage {
+		s.IncomingGarbage = int(r.uvarint())
+	}
+	for y := range s.Board {
+		s.Board[y] = r.row()
+	}
+	return s
+}
+
+func (r *frameReader) delta(version int) StateDelta {
+	d := StateDelta{Seq: r.uvarint()}
+	var mask uint64
+	if version >= versionGarbage {
+		mask = r.uvarint()
+	} else {
+		mask = uint64(r.byte())
+	}
+
+	if mask&deltaPiece != 0 {
+		piece := r.piece()
+		d.CurrentPiece = &piece
+	}
+	if mask&deltaNextPiece != 0 {
+		next := TetrominoType(r.byte())
+		d.NextPiece = &next
+	}
+	if mask&deltaScore != 0 {
+		score := int(r.uvarint())
+		d.Score = &score
+	}
+	if mask&deltaLevel != 0 {
+		level := int(r.uvarint())
+		d.Level = &level
+	}
+	if mask&deltaLines != 0 {
+		lines := int(r.uvarint())
+		d.LinesCleared = &lines
+	}
+	if mask&deltaGameOver != 0 {
+		over := r.byte() == 1
+		d.GameOver = &over
+	}
+	if mask&deltaRows != 0 {
+		n := int(r.byte())
+		for i := 0; i < n; i++ {
+			y := int(r.byte())
+			d.Rows = append(d.Rows, BoardRow{Y: y, Cells: r.row()})
+		}
+	}
+	if mask&deltaAck != 0 {
+		ack := r.uvarint()
+		d.Ack = &ack
+	}
+	if mask&deltaGarbage != 0 {
+		garbage := int(r.uvarint())
+		d.Garbage = &garbage
+	}
+	return d
+}
+
+// decodeServerFrame unpacks a frame from encodeBinary into the payload it
+// was made from
+func decodeServerFrame(t *testing.T, version int, frame []byte) any {
+	t.Helper()
+
+	r := &frameReader{b: frame[1:]}
+	var payload any
+	switch frame[0] {
+	case binStateUpdate:
+		s := r.state(version)
+		if len(r.b) == 0 {
+			payload = s
+		} else {
+			payload = ackedState{GameState: s, Ack: r.uvarint()}
+		}
+	case binSnapshot:
+		snapshot := StateSnapshot{Seq: r.uvarint(), State: r.state(version)}
+		if len(r.b) > 0 {
+			snapshot.Ack = r.uvarint()
+		}
+		payload = snapshot
+	case binDelta:
+		payload = r.delta(version)
+	case binJSON:
+		var msg Message
+		if err := json.Unmarshal(r.b, &msg); err != nil {
+			t.Fatalf("bad JSON frame: %v", err)
+		}
+		return msg
+	default:
+		t.Fatalf("unknown frame type 0x%02x", frame[0])
+	}
+
+	if r.bad {
+		t.Fatalf("frame ends too early")
+	}
+	if len(r.b) > 0 {
+		t.Fatalf("%d bytes left over", len(r.b))
+	}
+	return payload
+}
+
+func testState() GameState {
+	s := GameState{
+		CurrentPiece:    Tetromino{Type: L, X: -1, Y: 3, Rotation: 3},
+		NextPiece:       S,
+		Score:           123456,
+		Level:           12,
+		LinesCleared:    118,
+		IncomingGarbage: 4,
+	}
+	s.Board[BoardHeight-1] = [BoardWidth]int{garbageCell, garbageCell, 0, garbageCell, 1, 2, 3, 4, 5, 6}
+	s.Board[BoardHeight-2] = [BoardWidth]int{7, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	return s
+}
+
+func TestEncodeBinaryState(t *testing.T) {
+	state := testState()
+	v1State := state
+	v1State.IncomingGarbage = 0
+	over := state
+	over.GameOver = true
+
+	tests := []struct {
+		name    string
+		version int
+		msgType MessageType
+		payload any
+		want    any
+	}{
+		{"state", ProtocolVersion, StateUpdate, state, state},
+		{"state v1 drops garbage", 1, StateUpdate, state, v1State},
+		{"game over", ProtocolVersion, StateUpdate, over, over},
+		{"empty state", ProtocolVersion, StateUpdate, GameState{}, GameState{}},
+		{"acked state", ProtocolVersion, StateUpdate, ackedState{state, 77}, ackedState{state, 77}},
+		{"acked state without ack", ProtocolVersion, StateUpdate, ackedState{state, 0}, state},
+		{"snapshot", ProtocolVersion, Snapshot,
+			StateSnapshot{Seq: 1, State: state}, StateSnapshot{Seq: 1, State: state}},
+		{"snapshot with ack", ProtocolVersion, Snapshot,
+			StateSnapshot{Seq: 300, Ack: 5, State: state}, StateSnapshot{Seq: 300, Ack: 5, State: state}},
+		{"snapshot v1", 1, Snapshot,
+			StateSnapshot{Seq: 2, State: state}, StateSnapshot{Seq: 2, State: v1State}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := encodeBinary(tt.version, tt.msgType, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeServerFrame(t, tt.version, frame); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeBinaryDelta(t *testing.T) {
+	piece := Tetromino{Type: O, X: 8, Y: -2}
+	next := J
+	score, level, lines, garbage := 1<<20, 3, 25, 6
+	over := true
+	ack := uint64(1 << 40)
+	rows := []BoardRow{
+		{Y: 0, Cells: [BoardWidth]int{1, 2, 3, 4, 5, 6, 7, 8, 0, 15}},
+		{Y: BoardHeight - 1},
+	}
+	full := StateDelta{Seq: 9, CurrentPiece: &piece, NextPiece: &next, Score: &score,
+		Level: &level, LinesCleared: &lines, GameOver: &over, Garbage: &garbage,
+		Rows: rows, Ack: &ack}
+	v1Full := full
+	v1Full.Garbage = nil
+
+	tests := []struct {
+		name    string
+		version int
+		delta   StateDelta
+		want    StateDelta
+	}{
+		{"empty", ProtocolVersion, StateDelta{Seq: 1}, StateDelta{Seq: 1}},
+		{"piece", ProtocolVersion, StateDelta{Seq: 2, CurrentPiece: &piece}, StateDelta{Seq: 2, CurrentPiece: &piece}},
+		{"rows", ProtocolVersion, StateDelta{Seq: 3, Rows: rows}, StateDelta{Seq: 3, Rows: rows}},
+		{"ack", ProtocolVersion, StateDelta{Seq: 4, Ack: &ack}, StateDelta{Seq: 4, Ack: &ack}},
+		{"garbage", ProtocolVersion, StateDelta{Seq: 5, Garbage: &garbage}, StateDelta{Seq: 5, Garbage: &garbage}},
+		{"garbage v1", 1, StateDelta{Seq: 5, Garbage: &garbage}, StateDelta{Seq: 5}},
+		{"everything", ProtocolVersion, full, full},
+		{"everything v1", 1, full, v1Full},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := encodeBinary(tt.version, Delta, tt.delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeServerFrame(t, tt.version, frame); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeBinaryJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType MessageType
+		payload any
+		want    string
+	}{
+		{"queue status", QueueMsg, QueueStatus{Players: 2, Waiting: 3}, `{"players":2,"waiting":3,"waited_seconds":0}`},
+		{"queue left", QueueMsg, (*QueueStatus)(nil), `null`},
+		{"state as another type", Snapshot, GameState{}, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := encodeBinary(ProtocolVersion, tt.msgType, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frame[0] != binJSON {
+				t.Fatalf("frame type 0x%02x, want JSON", frame[0])
+			}
+			msg := decodeServerFrame(t, ProtocolVersion, frame).(Message)
+			if msg.Type != tt.msgType {
+				t.Errorf("type %q, want %q", msg.Type, tt.msgType)
+			}
+			if tt.want != "" && string(msg.Payload) != tt.want {
+				t.Errorf("payload %s, want %s", msg.Payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBinary(t *testing.T) {
+	moveJSON, _ := json.Marshal(Message{Type: Move, Payload: json.RawMessage(`{"dir":1}`)})
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    Message
+		wantErr bool
+	}{
+		{"move", []byte{binMove, byte(Rotate)}, Message{Type: Move, Payload: json.RawMessage(`{"dir":3}`)}, false},
+		{"move with seq", binary.AppendUvarint([]byte{binMove, byte(HardDrop)}, 300),
+			Message{Type: Move, Payload: json.RawMessage(`{"dir":4,"seq":300}`)}, false},
+		{"new game", []byte{binNewGame}, Message{Type: NewGame}, false},
+		{"resync", []byte{binResync}, Message{Type: Resync}, false},
+		{"json", append([]byte{binJSON}, moveJSON...), Message{Type: Move, Payload: json.RawMessage(`{"dir":1}`)}, false},
+		{"empty", nil, Message{}, true},
+		{"move without dir", []byte{binMove}, Message{}, true},
+		{"move with bad seq", []byte{binMove, 0, 0x80}, Message{}, true},
+		{"move with trailing bytes", []byte{binMove, 0, 1, 2}, Message{}, true},
+		{"bad json", []byte{binJSON, '{'}, Message{}, true},
+		{"server only type", []byte{binDelta}, Message{}, true},
+		{"unknown type", []byte{0x55}, Message{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBinary(tt.frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v (%s), want %+v (%s)", got, got.Payload, tt.want, tt.want.Payload)
+			}
+		})
+	}
+}
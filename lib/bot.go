@@ -0,0 +1,220 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+)
+
+// Bots fill the seats of a matchmade match nobody turned up for. A bot is a
+// game without a connection, played by a goroutine that works out where the
+// current piece fits best and walks it there one input at a time.
+
+const (
+	botName = "bot"
+
+	// botStep is how long a bot takes for each input
+	botStep = 150 * time.Millisecond
+)
+
+// Weights for rateBoard, from the well known hand tuned tetris AIs
+const (
+	botHeightWeight = -0.51
+	botLinesWeight  = 0.76
+	botHolesWeight  = -0.36
+	botBumpWeight   = -0.18
+)
+
+// newBot starts a game played by a bot
+func newBot(rules GameRules) *game {
+	id := "bot-" + newWatchID()
+	g := MakeNewGame(id, botName, rules, slog.With("session", id))
+	g.do(func() { g.bot = true })
+	go g.playBot()
+	return g
+}
+
+// playBot makes the bot's inputs until the game is stopped. The bot only
+// plays while the game is neither held nor over.
+func (g *game) playBot() {
+	ticker := time.NewTicker(botStep)
+	defer ticker.Stop()
+
+	// target is where the bot wants the piece it planned for, the one
+	// after pieces locked pieces in the game with seed
+	var target Tetromino
+	var seed int64
+	pieces := -1
+
+	for {
+		select {
+		case <-g.finished:
+			return
+		case <-ticker.C:
+		}
+
+		g.do(func() {
+			if g.state.GameOver || g.held {
+				return
+			}
+			if seed != g.seed || pieces != g.stats.Pieces {
+				seed, pieces = g.seed, g.stats.Pieces
+				target = g.bestLanding()
+			}
+			g.botMove(target)
+			g.send()
+		})
+	}
+}
+
+// botMove makes one input that takes the current piece closer to target,
+// dropping it once it is there or cannot get any closer
+func (g *game) botMove(target Tetromino) {
+	piece := g.state.CurrentPiece
+	dir := HardDrop
+	switch {
+	case piece.Rotation != target.Rotation:
+		dir = Rotate
+	case piece.X < target.X:
+		dir = Right
+	case piece.X > target.X:
+		dir = Left
+	}
+
+	if dir != HardDrop && g.MovePiece(dir) {
+		return
+	}
+	g.MovePiece(HardDrop)
+}
+
+// bestLanding returns where to drop the current piece. Every place it can
+// be dropped is tried with every place the next piece can go after it, and
+// the one that can lead to the best board wins.
+func (g *game) bestLanding() Tetromino {
+	best := g.state.CurrentPiece
+	bestRating := math.Inf(-1)
+	next := Tetromino{Type: g.state.NextPiece, X: BoardWidth/2 - 1}
+
+	for _, piece := range landings(&g.state.Board, g.state.CurrentPiece) {
+		board, lines := place(g.state.Board, piece)
+		rating := math.Inf(-1)
+		for _, second := range landings(&board, next) {
+			after, more := place(board, second)
+			rating = max(rating, rateBoard(&after, lines+more))
+		}
+		if rating > bestRating {
+			best, bestRating = piece, rating
+		}
+	}
+	return best
+}
+
+// landings lists every place piece can be dropped to by rotating it and
+// moving it sideways where it is
+func landings(board *[BoardHeight][BoardWidth]int, piece Tetromino) []Tetromino {
+	var found []Tetromino
+	for rotation := 0; rotation < 4; rotation++ {
+		for x := -2; x < BoardWidth; x++ {
+			landing := piece
+			landing.Rotation = rotation
+			landing.X = x
+			if !fits(board, landing) {
+				continue
+			}
+			for {
+				below := landing
+				below.Y++
+				if !fits(board, below) {
+					break
+				}
+				landing = below
+			}
+			found = append(found, landing)
+		}
+	}
+	return found
+}
+
+// place returns board with piece locked in and the full rows taken out,
+// and how many rows that was
+func place(board [BoardHeight][BoardWidth]int, piece Tetromino) ([BoardHeight][BoardWidth]int, int) {
+	for _, block := range tetrominoShapes[piece.Type][piece.Rotation] {
+		board[piece.Y+block[1]][piece.X+block[0]] = int(piece.Type) + 1
+	}
+
+	var cleared [BoardHeight][BoardWidth]int
+	lines := 0
+	to := BoardHeight - 1
+	for y := BoardHeight - 1; y >= 0; y-- {
+		if !slices.Contains(board[y][:], 0) {
+			lines++
+			continue
+		}
+		cleared[to] = board[y]
+		to--
+	}
+	return cleared, lines
+}
+
+// rateBoard scores a board the bot could leave behind: cleared lines are
+// good, a high, holey or bumpy stack is bad
+func rateBoard(board *[BoardHeight][BoardWidth]int, lines int) float64 {
+	height, holes, bumpiness := 0, 0, 0
+	previous := -1
+	for x := 0; x < BoardWidth; x++ {
+		column := 0
+		for y := 0; y < BoardHeight; y++ {
+			if board[y][x] == 0 {
+				if column > 0 {
+					holes++
+				}
+				continue
+			}
+			if column == 0 {
+				column = BoardHeight - y
+			}
+		}
+		height += column
+		if previous >= 0 {
+			bumpiness += abs(column - previous)
+		}
+		previous = column
+	}
+
+	return botHeightWeight*float64(height) + botLinesWeight*float64(lines) +
+		botHolesWeight*float64(holes) + botBumpWeight*float64(bumpiness)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,159 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+// Clients with the delta capability get a full snapshot when they say hello
+// or ask for a resync, and after that only what changed since the last
+// update. Every snapshot and delta carries the next number of a per
+// connection sequence, so a client that sees a gap knows its copy of the
+// state is off and sends a resync.
+
+// CapDelta is the capability for snapshot and delta state updates
+const CapDelta = "delta"
+
+// StateSnapshot is the full game state, the base later deltas apply to
+type StateSnapshot struct {
+	Seq   uint64    `json:"seq"`
+	Ack   uint64    `json:"ack,omitempty"` // with the ack capability, see MoveInput
+	State GameState `json:"state"`
+}
+
+// StateDelta holds the parts of the state that changed since the update
+// with the previous sequence number. Fields that did not change are left out.
+type StateDelta struct {
+	Seq          uint64         `json:"seq"`
+	CurrentPiece *Tetromino     `json:"current_piece,omitempty"`
+	NextPiece    *TetrominoType `json:"next_piece,omitempty"`
+	Score        *int           `json:"score,omitempty"`
+	Level        *int           `json:"level,omitempty"`
+	LinesCleared *int           `json:"lines_cleared,omitempty"`
+	GameOver     *bool          `json:"game_over,omitempty"`
+	Garbage      *int           `json:"incoming_garbage,omitempty"`
+	Rows         []BoardRow     `json:"rows,omitempty"` // changed rows, whole
+	Ack          *uint64        `json:"ack,omitempty"`  // with the ack capability, see MoveInput
+}
+
+// BoardRow is row Y of the board
+type BoardRow struct {
+	Y     int             `json:"y"`
+	Cells [BoardWidth]int `json:"cells"`
+}
+
+// diffState returns what changed from old to cur and whether anything did
+func diffState(old *GameState, cur *GameState) (StateDelta, bool) {
+	var delta StateDelta
+	changed := false
+
+	if cur.CurrentPiece != old.CurrentPiece {
+		piece := cur.CurrentPiece
+		delta.CurrentPiece = &piece
+		changed = true
+	}
+	if cur.NextPiece != old.NextPiece {
+		next := cur.NextPiece
+		delta.NextPiece = &next
+		changed = true
+	}
+	if cur.Score != old.Score {
+		score := cur.Score
+		delta.Score = &score
+		changed = true
+	}
+	if cur.Level != old.Level {
+		level := cur.Level
+		delta.Level = &level
+		changed = true
+	}
+	if cur.LinesCleared != old.LinesCleared {
+		lines := cur.LinesCleared
+		delta.LinesCleared = &lines
+		changed = true
+	}
+	if cur.GameOver != old.GameOver {
+		over := cur.GameOver
+		delta.GameOver = &over
+		changed = true
+	}
+	if cur.IncomingGarbage != old.IncomingGarbage {
+		garbage := cur.IncomingGarbage
+		delta.Garbage = &garbage
+		changed = true
+	}
+
+	for y := range cur.Board {
+		if cur.Board[y] != old.Board[y] {
+			delta.Rows = append(delta.Rows, BoardRow{Y: y, Cells: cur.Board[y]})
+			changed = true
+		}
+	}
+
+	return delta, changed
+}
+
+// sendDelta sends the client a snapshot if it has nothing to apply deltas
+// to, and otherwise what changed since its last update. Nothing is sent
+// when nothing changed.
+func (g *game) sendDelta(c *client) error {
+	acks := c.has(CapAck)
+
+	if c.sent == nil {
+		c.seq++
+		snapshot := StateSnapshot{Seq: c.seq, State: g.state}
+		if acks {
+			snapshot.Ack = c.ack
+		}
+		if err := g.sendTo(c, Snapshot, snapshot); err != nil {
+			return err
+		}
+		sent := g.state
+		c.sent = &sent
+		c.sentAck = snapshot.Ack
+		return nil
+	}
+
+	delta, changed := diffState(c.sent, &g.state)
+	if acks && c.ack != c.sentAck {
+		// The client waits for this even when the move changed nothing
+		ack := c.ack
+		delta.Ack = &ack
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	c.seq++
+	delta.Seq = c.seq
+	if err := g.sendTo(c, Delta, delta); err != nil {
+		return err
+	}
+	*c.sent = g.state
+	if delta.Ack != nil {
+		c.sentAck = *delta.Ack
+	}
+	return nil
+}
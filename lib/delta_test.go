@@ -0,0 +1,145 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// applyDelta does what a client does with a delta
+func applyDelta(s *GameState, d StateDelta) {
+	if d.CurrentPiece != nil {
+		s.CurrentPiece = *d.CurrentPiece
+	}
+	if d.NextPiece != nil {
+		s.NextPiece = *d.NextPiece
+	}
+	if d.Score != nil {
+		s.Score = *d.Score
+	}
+	if d.Level != nil {
+		s.Level = *d.Level
+	}
+	if d.LinesCleared != nil {
+		s.LinesCleared = *d.LinesCleared
+	}
+	if d.GameOver != nil {
+		s.GameOver = *d.GameOver
+	}
+	if d.Garbage != nil {
+		s.IncomingGarbage = *d.Garbage
+	}
+	for _, row := range d.Rows {
+		s.Board[row.Y] = row.Cells
+	}
+}
+
+func TestDiffStateRoundTrip(t *testing.T) {
+	base := GameState{
+		CurrentPiece: Tetromino{Type: T, X: 4},
+		NextPiece:    I,
+		Level:        1,
+
+		IncomingGarbage: 2,
+	}
+	base.Board[BoardHeight-1] = [BoardWidth]int{1, 1, 1, 1, 0, 1, 1, 1, 1, 1}
+
+	tests := []struct {
+		name   string
+		change func(s *GameState)
+		fields int // fields and rows the delta should carry
+	}{
+		{"nothing", func(s *GameState) {}, 0},
+		{"piece moved", func(s *GameState) { s.CurrentPiece.X++ }, 1},
+		{"piece rotated and dropped", func(s *GameState) {
+			s.CurrentPiece.Rotation = 1
+			s.CurrentPiece.Y = 5
+		}, 1},
+		{"next piece", func(s *GameState) { s.NextPiece = Z }, 1},
+		{"line cleared", func(s *GameState) {
+			s.Board[BoardHeight-1] = [BoardWidth]int{}
+			s.Score = 100
+			s.LinesCleared = 1
+		}, 3},
+		{"level up", func(s *GameState) { s.Level = 2 }, 1},
+		{"game over", func(s *GameState) { s.GameOver = true }, 1},
+		{"garbage queued", func(s *GameState) { s.IncomingGarbage = 3 }, 1},
+		{"garbage cleared", func(s *GameState) {
+			s.IncomingGarbage = 0
+			s.Board[0][0] = 2
+		}, 2},
+		{"rows filled", func(s *GameState) {
+			s.Board[3][2] = 5
+			s.Board[7][9] = 6
+			s.Board[BoardHeight-1][4] = 8
+		}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur := base
+			tt.change(&cur)
+
+			delta, changed := diffState(&base, &cur)
+			if changed != (tt.fields > 0) {
+				t.Fatalf("changed = %v, want %v", changed, tt.fields > 0)
+			}
+			if n := countDelta(delta); n != tt.fields {
+				t.Errorf("delta carries %d fields, want %d: %+v", n, tt.fields, delta)
+			}
+
+			// Through JSON, as the client gets it
+			data, err := json.Marshal(delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got StateDelta
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			applied := base
+			applyDelta(&applied, got)
+			if applied != cur {
+				t.Errorf("applying the delta gives %+v, want %+v", applied, cur)
+			}
+		})
+	}
+}
+
+// countDelta counts the fields and rows set in d
+func countDelta(d StateDelta) int {
+	n := len(d.Rows)
+	for _, set := range []bool{d.CurrentPiece != nil, d.NextPiece != nil, d.Score != nil,
+		d.Level != nil, d.LinesCleared != nil, d.GameOver != nil, d.Garbage != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
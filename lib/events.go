@@ -0,0 +1,150 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+// EventType names something that happened in a game
+type EventType string
+
+const (
+	EventPieceLocked     EventType = "piece_locked"
+	EventLinesCleared    EventType = "lines_cleared"
+	EventLevelUp         EventType = "level_up"
+	EventTSpin           EventType = "t_spin"
+	EventCombo           EventType = "combo"
+	EventGarbageSent     EventType = "garbage_sent"
+	EventGarbageReceived EventType = "garbage_received"
+	EventTopOut          EventType = "top_out"
+)
+
+// CapEvents is the capability for receiving game events
+const CapEvents = "events"
+
+// GameEvent is something that happened in a game. Which fields are set
+// depends on the type:
+//
+//	piece_locked      Piece where it locked
+//	lines_cleared     Rows, the cleared rows top down as they were before clearing
+//	level_up          Level, the new level
+//	t_spin            Piece and Lines, the lines it cleared which may be 0
+//	combo             Combo, how many clears in a row after the first
+//	garbage_sent      Lines of garbage sent to another player
+//	garbage_received  Lines of garbage added to the bottom of the board
+//	top_out           nothing, the game is over
+type GameEvent struct {
+	Type  EventType  `json:"type"`
+	Piece *Tetromino `json:"piece,omitempty"`
+	Rows  []int      `json:"rows,omitempty"`
+	Lines int        `json:"lines,omitempty"`
+	Level int        `json:"level,omitempty"`
+	Combo int        `json:"combo,omitempty"`
+}
+
+// GameStats are counted from a game's events and kept on the leaderboard
+type GameStats struct {
+	Pieces      int `json:"pieces"`
+	Singles     int `json:"singles"`
+	Doubles     int `json:"doubles"`
+	Triples     int `json:"triples"`
+	Tetrises    int `json:"tetrises"`
+	TSpins      int `json:"t_spins"`
+	MaxCombo    int `json:"max_combo"`
+	Garbage     int `json:"garbage"`      // lines of garbage received
+	GarbageSent int `json:"garbage_sent"` // lines of garbage sent
+}
+
+// count adds an event to the stats
+func (s *GameStats) count(ev GameEvent) {
+	switch ev.Type {
+	case EventPieceLocked:
+		s.Pieces++
+	case EventLinesCleared:
+		switch len(ev.Rows) {
+		case 1:
+			s.Singles++
+		case 2:
+			s.Doubles++
+		case 3:
+			s.Triples++
+		case 4:
+			s.Tetrises++
+		}
+	case EventTSpin:
+		s.TSpins++
+	case EventCombo:
+		s.MaxCombo = max(s.MaxCombo, ev.Combo)
+	case EventGarbageSent:
+		s.GarbageSent += ev.Lines
+	case EventGarbageReceived:
+		s.Garbage += ev.Lines
+	}
+}
+
+// emit records an event to be sent with the next state and counts it
+func (g *game) emit(ev GameEvent) {
+	g.events = append(g.events, ev)
+	g.stats.count(ev)
+	metrics.gameEvents.inc(string(ev.Type))
+}
+
+// isTSpin reports whether the current piece, about to lock, is a T that got
+// there by rotating with three of the four corners around its center taken.
+// Walls and the floor count as taken.
+func (g *game) isTSpin() bool {
+	p := g.state.CurrentPiece
+	if p.Type != T || !g.lastRotated {
+		return false
+	}
+
+	// Every rotation of the T has its center at (1, 1)
+	taken := 0
+	for _, corner := range [4][2]int{{0, 0}, {2, 0}, {0, 2}, {2, 2}} {
+		x := p.X + corner[0]
+		y := p.Y + corner[1]
+		if x < 0 || x >= BoardWidth || y < 0 || y >= BoardHeight || g.state.Board[y][x] != 0 {
+			taken++
+		}
+	}
+	return taken >= 3
+}
+
+// fullRows lists the rows that are ready to be cleared, top down
+func (g *game) fullRows() []int {
+	var rows []int
+	for y := 0; y < BoardHeight; y++ {
+		full := true
+		for x := 0; x < BoardWidth; x++ {
+			if g.state.Board[y][x] == 0 {
+				full = false
+				break
+			}
+		}
+		if full {
+			rows = append(rows, y)
+		}
+	}
+	return rows
+}
@@ -28,11 +28,13 @@ package gotris
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
-	"net/http"
-	"os"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -57,6 +59,53 @@ const (
 	Z
 )
 
+// GameMode names the rule set a game is played under
+type GameMode string
+
+const (
+	Marathon GameMode = "marathon"
+)
+
+// Versus is the leaderboard mode of games played in a room, kept apart from
+// games played alone since garbage changes what a score is worth
+const Versus GameMode = "versus"
+
+// gameModes lists every mode a server can be started with
+var gameModes = []GameMode{Marathon}
+
+// ParseGameMode checks that name is a mode we know how to play
+func ParseGameMode(name string) (GameMode, error) {
+	for _, mode := range gameModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown game mode %q", name)
+}
+
+// GameRules are the settings every game on a server is played with
+type GameRules struct {
+	Mode GameMode
+
+	// Gravity is how long a piece takes to fall one row at level 1. Every
+	// level after that is levelSpeed faster, down to minSpeed.
+	Gravity time.Duration
+
+	// LockDelay is how long a piece resting on the stack can still be moved
+	// before gravity locks it. Zero locks it on the spot.
+	LockDelay time.Duration
+
+	// InputRate caps the inputs a second a player may send, see inputGuard.
+	// Zero turns the cap off.
+	InputRate int
+}
+
+// Defaults for the game rules
+const (
+	DefaultGravity   = 800 * time.Millisecond
+	DefaultLockDelay = 0
+)
+
 // Direction for movement
 type Direction int
 
@@ -65,6 +114,7 @@ const (
 	Right
 	Down
 	Rotate
+	HardDrop
 )
 
 // Tetromino represents a tetris piece
@@ -84,6 +134,10 @@ type GameState struct {
 	Level        int                          `json:"level"`
 	LinesCleared int                          `json:"lines_cleared"`
 	GameOver     bool                         `json:"game_over"`
+
+	// IncomingGarbage is the lines of garbage waiting to come in, see
+	// AttackTable
+	IncomingGarbage int `json:"incoming_garbage"`
 }
 
 // Message types for websocket communication
@@ -94,27 +148,45 @@ const (
 	Move        MessageType = "move"
 	NewGame     MessageType = "new_game"
 	GameOverMsg MessageType = "game_over"
+	ShutdownMsg MessageType = "shutdown"
+	Hello       MessageType = "hello"
+	ErrorMsg    MessageType = "error"
+	Snapshot    MessageType = "snapshot"
+	Delta       MessageType = "delta"
+	Resync      MessageType = "resync"
+	EventsMsg   MessageType = "events"
+
+	RoomCreate    MessageType = "room_create"
+	RoomJoin      MessageType = "room_join"
+	RoomLeave     MessageType = "room_leave"
+	RoomReady     MessageType = "room_ready"
+	RoomConfigure MessageType = "room_settings"
+	RoomStart     MessageType = "room_start"
+	RoomRestart   MessageType = "room_restart"
+	RoomMsg       MessageType = "room"
+	QueueJoin     MessageType = "queue_join"
+	QueueLeave    MessageType = "queue_leave"
+	QueueMsg      MessageType = "queue"
 )
 
-// Message is the websocket message format
-type Message struct {
-	Type    MessageType     `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+// ShutdownNotice tells a client the server is going away and how long it
+// has left to finish its game.
+type ShutdownNotice struct {
+	DrainSeconds int `json:"drain_seconds"`
 }
 
-// Message is the websocket message format
-type RecvMessage struct {
-	Type    MessageType `json:"type"`
-	Payload Direction   `json:"payload"`
+// Message is the websocket message format in both directions. What the
+// payload holds depends on the type, see decodePayload.
+type Message struct {
+	Type    MessageType     `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 // Websocket upgrader
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections
-	},
+	CheckOrigin:     checkOrigin,
 }
 
 // Tetromino shapes - each shape has 4 rotations
@@ -163,24 +235,133 @@ var tetrominoShapes = map[TetrominoType][4][][]int{
 	},
 }
 
-// Game represents a single player's game session
+// Gravity speeds, the game gets faster every level down to minSpeed
+const (
+	levelSpeed = 50 * time.Millisecond
+	minSpeed   = 100 * time.Millisecond
+
+	writeWait = 10 * time.Second
+
+	// postedLen is how many posted calls a game queues up, see post
+	postedLen = 16
+)
+
+var (
+	errGameStopped = errors.New("game has been stopped")
+
+	// errConnLost wraps socket write errors. The client is gone but the
+	// session can wait for it to come back.
+	errConnLost = errors.New("connection lost")
+)
+
+// Game represents a single player's game session. Everything but lastActive
+// belongs to the goroutine started by Start, other goroutines talk to the
+// game through its channels.
 type game struct {
-	state  GameState
-	conn   *websocket.Conn
-	id     string
-	ticker *time.Ticker
-	done   chan bool
-	speed  time.Duration
+	state    GameState
+	id       string
+	name     string
+	mode     GameMode
+	seed     int64
+	rng      *rand.Rand
+	started  time.Time
+	recorded bool
+	rules    GameRules
+	speed    time.Duration
+	landed   bool // the piece touched the stack and waits for its lock delay
+	guard    inputGuard
+	client   *client // nil while nobody is attached
+	log      *slog.Logger
+
+	events      []GameEvent // emitted since the last state was sent
+	stats       GameStats
+	combo       int  // clears in a row, -1 after a lock that cleared nothing
+	lastRotated bool // the last thing the current piece did was rotate
+
+	watchID string // public id spectators find the game by, never changes
+	room    *room  // the room the game is played in, nil when on its own
+	match   int    // the room's match being played
+	held    bool   // waiting for the room's next match
+	bot     bool   // played by the server, see newBot
+
+	attackTable *AttackTable      // what clears send in the room's match, nil for no garbage
+	incoming    []incomingGarbage // garbage sent to us, oldest first
+	backToBack  bool              // the last clear was a Tetris or T-spin
+
+	watchers map[*client]struct{} // spectators, see Watch
+	attaches chan *client
+	detaches chan *client
+	inputs   chan clientInput
+	calls    chan func()
+	posted   chan func() // see post
+	quit     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+
+	// lastActive is when the client last sent something, in UnixNano
+	lastActive atomic.Int64
+
+	// watcherCount is len(watchers) for other goroutines
+	watcherCount atomic.Int32
+}
+
+// client is one websocket connection attached to a game. Its reader runs in
+// Attach while all writes happen on the game goroutine, except for
+// spectators whose writes are queued, see Watch.
+type client struct {
+	conn *websocket.Conn
+	log  *slog.Logger // the game's logger plus the client's address
+	err  error        // why the game gave up on the connection, nil if it did not
+
+	// version and capabilities are what the client agreed to in its hello,
+	// version is 0 until then
+	version      int
+	capabilities []string
+
+	// sent is the state as the client knows it from its snapshot and deltas,
+	// nil until it has had a snapshot. seq numbers the updates sent.
+	sent *GameState
+	seq  uint64
+
+	// ack is the sequence number of the last move processed, sentAck the
+	// ack the client was last told about
+	ack     uint64
+	sentAck uint64
+
+	// queue is where a spectator's frames wait for its writer, nil for the
+	// player
+	queue chan outFrame
+
+	// released is closed once the game no longer uses the connection
+	released chan struct{}
+}
+
+// clientInput is a message read from a client, or why it could not be read
+type clientInput struct {
+	from *client
+	msg  Message
+	err  error
 }
 
-// NewGame creates a new game instance
-func MakeNewGame(conn *websocket.Conn, id string) *game {
-	fmt.Printf("New Game clicked %s\n", id)
+// NewGame creates a new game instance. log is the session's logger, the
+// game's mode changes with its room so it is logged where it matters.
+func MakeNewGame(id string, name string, rules GameRules, log *slog.Logger) *game {
 	g := &game{
-		conn:  conn,
-		id:    id,
-		done:  make(chan bool),
-		speed: 800 * time.Millisecond, // Starting speed
+		log:      log,
+		watchers: make(map[*client]struct{}),
+		watchID:  newWatchID(),
+		id:       id,
+		name:     name,
+		mode:     rules.Mode,
+		rules:    rules,
+		guard:    newInputGuard(rules.InputRate),
+		attaches: make(chan *client),
+		detaches: make(chan *client),
+		inputs:   make(chan clientInput),
+		calls:    make(chan func()),
+		posted:   make(chan func(), postedLen),
+		quit:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 
 	g.Reset()
@@ -190,6 +371,26 @@ func MakeNewGame(conn *websocket.Conn, id string) *game {
 
 // Reset the game to starting state
 func (g *game) Reset() {
+	// Every game gets its own seed so a run can be identified and replayed
+	g.resetWith(time.Now().UnixNano())
+}
+
+// resetWith starts a game whose pieces come from seed
+func (g *game) resetWith(seed int64) {
+	g.seed = seed
+	g.rng = rand.New(rand.NewSource(g.seed))
+	g.started = time.Now()
+	g.recorded = false
+	g.speed = g.rules.Gravity
+	g.landed = false
+	g.guard.reset()
+	g.events = nil
+	g.stats = GameStats{}
+	g.combo = -1
+	g.lastRotated = false
+	g.incoming = nil
+	g.backToBack = false
+
 	g.state = GameState{
 		Level:        1,
 		Score:        0,
@@ -205,8 +406,11 @@ func (g *game) Reset() {
 	}
 
 	// Generate first pieces
-	g.state.NextPiece = TetrominoType(rand.Intn(7))
+	g.state.NextPiece = TetrominoType(g.rng.Intn(7))
 	g.SpawnNewPiece()
+
+	metrics.gamesStarted.inc(string(g.mode))
+	g.logger().Info("new game", "player", g.name, "mode", g.mode, "seed", g.seed)
 }
 
 // SpawnNewPiece creates a new tetromino at the top of the board
@@ -218,17 +422,74 @@ func (g *game) SpawnNewPiece() {
 		Rotation: 0,
 	}
 
+	g.landed = false
+	g.lastRotated = false
+	g.guard.newPiece()
+
 	// Generate next piece
-	g.state.NextPiece = TetrominoType(rand.Intn(7))
+	g.state.NextPiece = TetrominoType(g.rng.Intn(7))
 
 	// Check if the new piece can be placed - if not, game over
 	if !g.isValidPosition(g.state.CurrentPiece) {
-		g.state.GameOver = true
+		g.topOut()
+	}
+}
+
+// topOut ends the game, the stack has reached the top
+func (g *game) topOut() {
+	g.state.GameOver = true
+	g.emit(GameEvent{Type: EventTopOut})
+	g.logger().Info("game over", "player", g.name, "mode", g.mode, "score", g.state.Score,
+		"lines", g.state.LinesCleared, "level", g.state.Level,
+		"duration", time.Since(g.started).Round(time.Second).String())
+	metrics.gamesFinished.inc(string(g.mode))
+	metrics.scores.observe(string(g.mode), float64(g.state.Score))
+	g.RecordResult()
+	if g.room != nil {
+		go g.room.toppedOut(g, g.match, g.state.Score)
 	}
 }
 
+// RecordResult saves the game to the leaderboard. It is safe to call more
+// than once, only the first call for a game is recorded. Games abandoned
+// before scoring anything are not worth keeping.
+func (g *game) RecordResult() {
+	if g.recorded || scores == nil || g.bot {
+		return
+	}
+	if !g.state.GameOver && g.state.Score == 0 {
+		return
+	}
+	g.recorded = true
+
+	mode := g.mode
+	if g.room != nil {
+		mode = Versus
+	}
+
+	entry := ScoreEntry{
+		Name:       g.name,
+		Mode:       mode,
+		Score:      g.state.Score,
+		Lines:      g.state.LinesCleared,
+		Level:      g.state.Level,
+		DurationMS: time.Since(g.started).Milliseconds(),
+		Seed:       g.seed,
+		FinishedAt: time.Now(),
+		Flagged:    g.guard.flag,
+		Stats:      g.stats,
+	}
+
+	scores.Add(entry, g.logger())
+}
+
 // isValidPosition checks if a tetromino's position is valid
 func (g *game) isValidPosition(t Tetromino) bool {
+	return fits(&g.state.Board, t)
+}
+
+// fits checks if a tetromino's position is valid on board
+func fits(board *[BoardHeight][BoardWidth]int, t Tetromino) bool {
 	shape := tetrominoShapes[t.Type][t.Rotation]
 
 	for _, block := range shape {
@@ -241,7 +502,7 @@ func (g *game) isValidPosition(t Tetromino) bool {
 		}
 
 		// Check collision with existing blocks
-		if y >= 0 && g.state.Board[y][x] != 0 {
+		if y >= 0 && board[y][x] != 0 {
 			return false
 		}
 	}
@@ -263,11 +524,22 @@ func (g *game) MovePiece(dir Direction) bool {
 		newPiece.Y++
 	case Rotate:
 		newPiece.Rotation = (newPiece.Rotation + 1) % 4
+	case HardDrop:
+		// Drop straight to the stack and lock there
+		for g.canFall() {
+			g.state.CurrentPiece.Y++
+			g.lastRotated = false
+		}
+		g.LockPiece()
+		return true
 	}
 
 	// Check if new position is valid
 	if g.isValidPosition(newPiece) {
 		g.state.CurrentPiece = newPiece
+		g.lastRotated = dir == Rotate
+		// Sliding off the edge of the stack cancels the lock delay
+		g.landed = g.landed && !g.canFall()
 		return true
 	}
 
@@ -280,6 +552,30 @@ func (g *game) MovePiece(dir Direction) bool {
 	return false
 }
 
+// canFall reports whether the current piece has room to move down a row
+func (g *game) canFall() bool {
+	below := g.state.CurrentPiece
+	below.Y++
+	return g.isValidPosition(below)
+}
+
+// Fall is one step of gravity. Unlike a move down by the player a piece that
+// hits the stack is not locked right away when the rules have a lock delay,
+// it is marked as landed and the game goroutine locks it once the delay has
+// passed.
+func (g *game) Fall() {
+	if g.canFall() {
+		g.MovePiece(Down)
+		return
+	}
+
+	if g.rules.LockDelay > 0 {
+		g.landed = true
+		return
+	}
+	g.LockPiece()
+}
+
 // LockPiece fixes the current piece to the board
 func (g *game) LockPiece() {
 	// Add the piece to the board
@@ -294,14 +590,39 @@ func (g *game) LockPiece() {
 		}
 	}
 
+	piece := g.state.CurrentPiece
+	g.emit(GameEvent{Type: EventPieceLocked, Piece: &piece})
+
+	tSpin := g.isTSpin()
+	rows := g.fullRows()
+
 	// Check for completed lines
 	linesCleared := g.ClearLines()
 
+	if tSpin {
+		g.emit(GameEvent{Type: EventTSpin, Piece: &piece, Lines: linesCleared})
+	}
+	if linesCleared > 0 {
+		g.emit(GameEvent{Type: EventLinesCleared, Rows: rows})
+		g.combo++
+		if g.combo > 0 {
+			g.emit(GameEvent{Type: EventCombo, Combo: g.combo})
+		}
+	} else {
+		g.combo = -1
+	}
+
 	// Update score
 	if linesCleared > 0 {
 		g.UpdateScore(linesCleared)
 	}
 
+	// Send and take garbage in a match that has it
+	if g.attackTable != nil && !g.tradeGarbage(linesCleared, tSpin) {
+		g.topOut()
+		return
+	}
+
 	// Spawn new piece
 	g.SpawnNewPiece()
 }
@@ -366,122 +687,589 @@ func (g *game) UpdateScore(linesCleared int) {
 	newLevel := (g.state.LinesCleared / 10) + 1
 	if newLevel > g.state.Level {
 		g.state.Level = newLevel
-		g.speed = time.Duration(800-((newLevel-1)*50)) * time.Millisecond
-		if g.speed < 100*time.Millisecond {
-			g.speed = 100 * time.Millisecond
-		}
-
-		// Reset ticker with new speed
-		if g.ticker != nil {
-			g.ticker.Stop()
-			g.ticker = time.NewTicker(g.speed)
+		g.emit(GameEvent{Type: EventLevelUp, Level: newLevel})
+		g.speed = g.rules.Gravity - time.Duration(newLevel-1)*levelSpeed
+		if g.speed < minSpeed {
+			g.speed = min(minSpeed, g.rules.Gravity)
 		}
 	}
 }
 
-// SendState sends the current game state to the client
+// SendState sends the current game state to the client, doing nothing when
+// no client is attached. Only the game goroutine may call it.
 func (g *game) SendState() error {
-	stateJSON, err := json.Marshal(g.state)
-	if err != nil {
-		log.Printf("Err::SendState state with %s (%v)\n", g.id, err)
-		return err
+	if g.client == nil {
+		return nil
 	}
+	return g.sendStateTo(g.client)
+}
 
-	msg := Message{
-		Type:    StateUpdate,
-		Payload: stateJSON,
+// sendStateTo sends the current game state to c, as a delta if it asked for
+// those
+func (g *game) sendStateTo(c *client) error {
+	if c.has(CapDelta) {
+		return g.sendDelta(c)
+	}
+	return g.sendTo(c, StateUpdate, g.statePayload(c))
+}
+
+// SendMessage sends a message of the given type to the client, doing nothing
+// when no client is attached. Only the game goroutine may call it.
+func (g *game) SendMessage(msgType MessageType, payload any) error {
+	if g.client == nil {
+		return nil
 	}
+	return g.sendTo(g.client, msgType, payload)
+}
 
-	msgJSON, err := json.Marshal(msg)
+// sendTo sends a message to c, the player or a spectator
+func (g *game) sendTo(c *client, msgType MessageType, payload any) error {
+	frameType, data, err := encodeMessage(c, msgType, payload)
 	if err != nil {
-		log.Printf("Err::SendState msg with %s (%v)\n", g.id, err)
+		c.log.Error("failed to encode message", "type", msgType, "err", err)
 		return err
 	}
 
-	err = g.conn.WriteMessage(websocket.TextMessage, msgJSON)
+	if c.queue != nil {
+		return c.enqueue(outFrame{msgType: msgType, frameType: frameType, data: data})
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err = c.conn.WriteMessage(frameType, data)
 	if err != nil {
-		log.Printf("Err::SendState sock write with %s (%v)\n", g.id, err)
-		return err
+		metrics.sendErrors.inc("")
+		c.log.Warn("failed to send message", "type", msgType, "err", err)
+		return fmt.Errorf("%w: %w", errConnLost, err)
 	}
+	metrics.messagesSent.inc(string(msgType))
 	return nil
 }
 
-// Start begins the game loop
-func (g *game) Start() {
-	g.ticker = time.NewTicker(g.speed)
+// encodeMessage turns a message into a websocket frame in the encoding the
+// client agreed to. The answer to a hello is always JSON, the client only
+// switches to binary once it has read it.
+func encodeMessage(c *client, msgType MessageType, payload any) (frameType int, data []byte, err error) {
+	if c.has(CapBinary) && msgType != Hello {
+		data, err = encodeBinary(c.version, msgType, payload)
+		return websocket.BinaryMessage, data, err
+	}
 
-	// Send initial state
-	err := g.SendState()
+	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error in Start when %s sending State %v\n", g.id, err)
-		os.Exit(1)
-	}
-
-	// Start game loop
-	go func() {
-		for {
-			select {
-			case <-g.ticker.C:
-				if !g.state.GameOver {
-					g.MovePiece(Down)
-					err := g.SendState()
-					if err != nil {
-						log.Printf("Error in Start when %s sending State %v\n", g.id, err)
-						os.Exit(1)
-					}
+		return 0, nil, err
+	}
 
-				}
-			case <-g.done:
-				g.ticker.Stop()
-				return
-			default:
-				time.Sleep(100 * time.Millisecond)
+	msg := Message{
+		Type:    msgType,
+		Payload: payloadJSON,
+	}
+
+	data, err = json.Marshal(msg)
+	return websocket.TextMessage, data, err
+}
+
+// Start runs the game goroutine. It owns the game state and the write side
+// of the attached connection, and serializes client input, gravity ticks and
+// calls from other goroutines. The game keeps running until Stop is called
+// but only falls while a client is attached.
+func (g *game) Start() {
+	go g.run()
+}
+
+func (g *game) run() {
+	ticker := time.NewTicker(g.speed)
+	speed := g.speed
+
+	// lockC fires when a landed piece's lock delay is up, nil otherwise
+	var lockTimer *time.Timer
+	var lockC <-chan time.Time
+
+	// pingC keeps the client's pongs coming, nil when pings are turned off
+	var pingC <-chan time.Time
+	if limits.pingInterval > 0 {
+		pingTicker := time.NewTicker(limits.pingInterval)
+		defer pingTicker.Stop()
+		pingC = pingTicker.C
+	}
+
+	defer func() {
+		ticker.Stop()
+		if r := recover(); r != nil {
+			g.logger().Error("game panic", "panic", r)
+			err := fmt.Errorf("game panic: %v", r)
+			if g.client != nil {
+				g.release(g.client, err)
+			}
+			for w := range g.watchers {
+				g.release(w, err)
 			}
 		}
+		close(g.finished)
 	}()
 
-	// Listen for client messages
 	for {
-		_, rawMessage, err := g.conn.ReadMessage()
-		if err != nil {
-			fmt.Printf("%s client closed\n", g.id)
-			break
-		}
+		select {
+		case c := <-g.attaches:
+			if g.client != nil {
+				g.release(g.client, nil)
+			}
+			g.client = c
+			g.update(c, nil)
+			if g.room != nil {
+				go g.room.refresh(g)
+			}
 
-		var message RecvMessage
-		if err := json.Unmarshal(rawMessage, &message); err != nil {
-			log.Println("JSON error:", err)
-			continue
-		}
+		case c := <-g.detaches:
+			if c == g.client || g.isWatching(c) {
+				g.release(c, nil)
+			}
 
-		switch message.Type {
-		case Move:
-			if g.state.GameOver {
+		case in := <-g.inputs:
+			if g.isWatching(in.from) {
+				g.handleWatcher(in)
 				continue
 			}
+			if in.from != g.client {
+				// Left over from a connection we already let go of
+				continue
+			}
+			g.handle(in)
+
+		case f := <-g.calls:
+			g.runPosted()
+			f()
+
+		case f := <-g.posted:
+			f()
 
-			g.MovePiece(message.Payload)
-			err := g.SendState()
-			if err != nil {
-				log.Printf("Error in Start when %s sending State %v\n", g.id, err)
-				os.Exit(1)
+		case <-pingC:
+			if g.client != nil {
+				g.ping()
 			}
 
-		case NewGame:
-			g.Reset()
-			if g.ticker != nil {
-				g.ticker.Stop()
+		case tick := <-ticker.C:
+			metrics.tickLag.observe("", time.Since(tick).Seconds())
+			if g.client != nil && !g.state.GameOver && !g.landed && !g.held {
+				g.Fall()
+				g.send()
 			}
-			g.ticker = time.NewTicker(g.speed)
-			err := g.SendState()
-			if err != nil {
-				log.Printf("Error in Start when %s sending State %v\n", g.id, err)
-				os.Exit(1)
+
+		case <-lockC:
+			lockC = nil
+			if g.client != nil && g.landed && !g.state.GameOver && !g.held {
+				g.landed = false
+				if !g.canFall() {
+					g.LockPiece()
+				}
+				g.send()
+			}
+
+		case <-g.quit:
+			if g.client != nil {
+				g.release(g.client, errGameStopped)
 			}
+			for w := range g.watchers {
+				closeWithReason(w.conn, closeGameEnded, "The game has ended")
+				g.release(w, errGameStopped)
+			}
+			g.RecordResult()
+			return
+		}
+
+		// Level ups and new games change how fast pieces fall
+		if speed != g.speed {
+			speed = g.speed
+			ticker.Reset(speed)
+		}
+
+		switch {
+		case g.landed && lockC == nil:
+			lockTimer = time.NewTimer(g.rules.LockDelay)
+			lockC = lockTimer.C
+		case !g.landed && lockC != nil:
+			lockTimer.Stop()
+			lockC = nil
+		}
+	}
+}
+
+// handle applies a client message to the game, answering messages it cannot
+// make sense of with an error
+func (g *game) handle(in clientInput) {
+	flagged := g.guard.flag != ""
+	defer func() {
+		if !flagged && g.guard.flag != "" {
+			g.logger().Warn("flagged as scripted", "player", g.name, "mode", g.mode, "reason", g.guard.flag)
+		}
+	}()
+
+	if !g.guard.allow(time.Now()) {
+		g.ackDropped(in)
+		return
+	}
+
+	if in.err != nil {
+		g.sendError(in.from, ErrorNotice{Code: ErrMalformed, Message: in.err.Error()})
+		return
+	}
+
+	message := in.msg
+	switch message.Type {
+	case Hello:
+		g.hello(in.from, message)
+
+	case Move:
+		var move MoveInput
+		if err := decodePayload(message, &move); err != nil {
+			g.sendError(in.from, ErrorNotice{Code: ErrBadPayload, Type: message.Type, Message: err.Error()})
+			return
+		}
+		if move.Seq > g.client.ack {
+			g.client.ack = move.Seq
+		}
+		if g.state.GameOver || g.held || !g.guard.checkMove(move.Dir, time.Now()) {
+			return
+		}
+
+		g.MovePiece(move.Dir)
+		g.send()
+
+	case NewGame:
+		if g.room != nil {
+			g.sendError(in.from, ErrorNotice{Code: ErrRoom, Type: message.Type, Message: errRoomNewGame.Error()})
+			return
+		}
+		g.RecordResult()
+		g.Reset()
+		g.send()
+
+	case Resync:
+		g.resync(in.from)
+
+	default:
+		g.sendError(in.from, ErrorNotice{
+			Code:    ErrUnknownType,
+			Type:    message.Type,
+			Message: fmt.Sprintf("unknown message type %q", message.Type),
+		})
+	}
+}
+
+// hello decodes a client's hello and greets it
+func (g *game) hello(c *client, message Message) {
+	var hello ClientHello
+	if err := decodePayload(message, &hello); err != nil {
+		g.sendError(c, ErrorNotice{Code: ErrBadPayload, Type: message.Type, Message: err.Error()})
+		return
+	}
+	g.greet(c, hello)
+}
+
+// greet answers the client's hello with the protocol version and
+// capabilities the connection uses from now on, followed by the full state.
+// Clients without a version in common are disconnected.
+func (g *game) greet(c *client, hello ClientHello) {
+	reply, ok := negotiate(hello)
+	if !ok {
+		g.sendError(c, ErrorNotice{
+			Code: ErrUnsupportedVersion,
+			Type: Hello,
+			Message: fmt.Sprintf("server speaks protocol versions %d to %d",
+				minProtocolVersion, ProtocolVersion),
+		})
+		c.log.Warn("no protocol version in common", "versions", hello.Versions, "client", hello.Client)
+		closeWithReason(c.conn, closeUnsupportedProtocol, "This client is too old or too new for the server")
+		return
+	}
+
+	c.version = reply.Version
+	c.capabilities = reply.Capabilities
+	c.sent = nil
+	c.log.Debug("hello", "version", reply.Version, "capabilities", reply.Capabilities, "client", hello.Client)
+
+	if err := g.sendTo(c, Hello, reply); err != nil {
+		g.release(c, err)
+		return
+	}
+	g.update(c, nil)
+}
+
+// resync sends c a fresh snapshot
+func (g *game) resync(c *client) {
+	c.sent = nil
+	g.update(c, nil)
+}
+
+// sendError tells the client what was wrong with its last message
+func (g *game) sendError(c *client, notice ErrorNotice) {
+	c.log.Debug("bad message", "code", notice.Code, "type", notice.Type, "err", notice.Message)
+	if err := g.sendTo(c, ErrorMsg, notice); err != nil {
+		g.release(c, err)
+	}
+}
+
+// send pushes the events since the last send and the state to the attached
+// client and the spectators. Connections that fail are let go of, for the
+// player the error ends up being returned by its Attach.
+func (g *game) send() {
+	events := g.events
+	g.events = nil
+
+	if g.client != nil {
+		g.update(g.client, events)
+	}
+	for w := range g.watchers {
+		g.update(w, events)
+	}
+}
+
+// update sends c the events it wants and the state they led to, letting go
+// of c if that fails
+func (g *game) update(c *client, events []GameEvent) {
+	var err error
+	if len(events) > 0 && c.has(CapEvents) {
+		err = g.sendTo(c, EventsMsg, events)
+	}
+	if err == nil {
+		err = g.sendStateTo(c)
+	}
+	if err != nil {
+		g.release(c, err)
+	}
+}
+
+// logger is the game's logger, with the client's address while one is
+// attached. Only the game goroutine may call it.
+func (g *game) logger() *slog.Logger {
+	if g.client != nil {
+		return g.client.log
+	}
+	return g.log
+}
+
+// ping asks the client for a pong. Not hearing back in time makes the read
+// in Attach fail, see heartbeat.
+func (g *game) ping() {
+	err := g.client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+	if err != nil {
+		g.release(g.client, fmt.Errorf("%w: ping: %w", errConnLost, err))
+	}
+}
+
+// release stops the game using c's connection. A non nil err closes the
+// connection so its reader in Attach wakes up and reports err.
+func (g *game) release(c *client, err error) {
+	if g.client == c {
+		g.client = nil
+	}
+	if g.isWatching(c) {
+		delete(g.watchers, c)
+		g.watcherCount.Store(int32(len(g.watchers)))
+		close(c.queue)
+	}
+	c.err = err
+	close(c.released)
+	if err != nil {
+		c.conn.Close()
+	}
+}
+
+// post runs f on the game goroutine without waiting for it, for callers
+// that must not be held up by a slow client. Posted calls run in order and
+// before any later do. Once postedLen of them are waiting the rest go
+// through do on goroutines of their own, in no particular order.
+func (g *game) post(f func()) {
+	select {
+	case g.posted <- f:
+	default:
+		go g.do(f)
+	}
+}
+
+// runPosted runs the posted calls that are waiting
+func (g *game) runPosted() {
+	for {
+		select {
+		case f := <-g.posted:
+			f()
+		default:
+			return
+		}
+	}
+}
+
+// do runs f on the game goroutine and waits for it to finish. It returns
+// false without running f if the game has already stopped, or if f panicked
+// and took the game down with it.
+func (g *game) do(f func()) bool {
+	done := make(chan struct{})
+	call := func() {
+		f()
+		close(done)
+	}
+
+	select {
+	case g.calls <- call:
+	case <-g.finished:
+		return false
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-g.finished:
+		// f may have finished the game itself, otherwise it panicked
+		select {
+		case <-done:
+			return true
+		default:
+			return false
 		}
 	}
+}
+
+// IsOver reports whether the current game has ended
+func (g *game) IsOver() bool {
+	over := true
+	g.do(func() { over = g.state.GameOver })
+	return over
+}
+
+// Playing reports whether the game is being played, neither over nor held
+// waiting for its room
+func (g *game) Playing() bool {
+	playing := false
+	g.do(func() { playing = !g.state.GameOver && !g.held })
+	return playing
+}
+
+// SetName changes the player name recorded with the game
+func (g *game) SetName(name string) {
+	g.do(func() { g.name = name })
+}
+
+// NotifyShutdown warns the client that the server stops after drain
+func (g *game) NotifyShutdown(drain time.Duration) {
+	g.do(func() {
+		notice := ShutdownNotice{DrainSeconds: int(drain.Seconds())}
+		err := g.SendMessage(ShutdownMsg, notice)
+		if err != nil && g.client != nil {
+			g.release(g.client, err)
+		}
+		for w := range g.watchers {
+			if err := g.sendTo(w, ShutdownMsg, notice); err != nil {
+				g.release(w, err)
+			}
+		}
+	})
+}
+
+// IdleFor reports how long it has been since the client last sent anything
+func (g *game) IdleFor() time.Duration {
+	return time.Since(time.Unix(0, g.lastActive.Load()))
+}
+
+// Stop ends the game for good, records the result and waits for the game
+// goroutine to exit.
+func (g *game) Stop() {
+	g.stopOnce.Do(func() { close(g.quit) })
+	<-g.finished
+}
 
-	g.conn.Close()
-	g.done <- true
+// heartbeat makes a connection fail its reads once the client has been
+// silent for the read timeout. Any message or pong from the client pushes the
+// deadline back, and the game's pings make sure a live client always has
+// something to answer.
+func heartbeat(conn *websocket.Conn) {
+	extendReadDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		extendReadDeadline(conn)
+		return nil
+	})
+}
+
+func extendReadDeadline(conn *websocket.Conn) {
+	if limits.readTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(limits.readTimeout))
+	}
+}
+
+// Attach hands the game a client connection, sends it the full state and
+// serves its messages until the connection goes away. The game is left
+// paused, waiting for the next Attach or a Stop. A nil error means the client
+// went away on its own, anything else means the session is broken.
+func (g *game) Attach(conn *websocket.Conn) error {
+	c := &client{
+		conn:     conn,
+		log:      slog.With("session", g.id, "remote", conn.RemoteAddr().String()),
+		released: make(chan struct{}),
+	}
+	defer conn.Close()
+
+	g.lastActive.Store(time.Now().UnixNano())
+	heartbeat(conn)
+
+	select {
+	case g.attaches <- c:
+	case <-g.finished:
+		return errGameStopped
+	}
+
+	return g.read(c)
+}
+
+// read serves the messages of a client until its connection goes away and
+// returns why the game let go of it
+func (g *game) read(c *client) error {
+	conn := c.conn
+	for {
+		frameType, rawMessage, err := conn.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			c.log.Info("disconnected", "reason", "stopped answering pings")
+			break
+		}
+		if err != nil {
+			c.log.Info("disconnected", "reason", err)
+			break
+		}
+		extendReadDeadline(conn)
+		if c.queue == nil {
+			g.lastActive.Store(time.Now().UnixNano())
+		}
+
+		in := clientInput{from: c}
+		if frameType == websocket.BinaryMessage {
+			in.msg, in.err = decodeBinary(rawMessage)
+		} else if err := json.Unmarshal(rawMessage, &in.msg); err != nil {
+			in.err = fmt.Errorf("message is not valid JSON: %w", err)
+		}
+		if in.err != nil {
+			metrics.messagesReceived.inc("invalid")
+		} else {
+			metrics.messagesReceived.inc(receivedTypeLabel(in.msg.Type))
+		}
+
+		// Room and queue messages are served here, see room.go
+		if in.err == nil && c.queue == nil && isLobbyMessage(in.msg.Type) {
+			rooms.handle(g, c, in.msg)
+			continue
+		}
+
+		select {
+		case g.inputs <- in:
+		case <-c.released:
+		}
+	}
+
+	// Tell the game we are gone unless it already let go of us
+	select {
+	case g.detaches <- c:
+	case <-c.released:
+	case <-g.finished:
+		return errGameStopped
+	}
+	<-c.released
 
+	return c.err
 }
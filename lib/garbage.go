@@ -0,0 +1,303 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"time"
+)
+
+// Garbage is what makes a match a fight. Clearing lines sends rows of
+// garbage to another player in the room, who gets them pushed in from the
+// bottom of the board with a hole to clear them by. The room's garbage rule
+// names the attack table that says how much each clear sends. Garbage sent
+// to a player waits in their incoming queue for the table's entry delay and
+// comes in when they lock a piece without clearing anything. Clearing lines
+// first cancels incoming garbage and only what is left over is sent on.
+
+// Garbage rules every server has, LoadAttackTables adds more
+const (
+	GarbageStandard GarbageRule = "standard"
+	GarbageClassic  GarbageRule = "classic"
+)
+
+// HoleRule says where the holes in a batch of garbage go
+type HoleRule string
+
+const (
+	HolesColumn HoleRule = "column" // every row of an attack has its hole in the same column
+	HolesRandom HoleRule = "random" // every row gets a hole of its own
+)
+
+// garbageCell is the board value of a garbage block, pieces are 1 to 7
+const garbageCell = 8
+
+// AttackTable is how many lines of garbage each kind of clear sends, where
+// their holes go and how long they wait before they come in
+type AttackTable struct {
+	Lines        [5]int   `json:"lines"`         // by lines cleared, the first for clearing none
+	TSpin        [4]int   `json:"t_spin"`        // by lines cleared, instead of lines
+	Combo        []int    `json:"combo"`         // added by combo, the last for longer ones
+	BackToBack   int      `json:"back_to_back"`  // added to a Tetris or T-spin clear after another
+	PerfectClear int      `json:"perfect_clear"` // added when a clear empties the board
+	Holes        HoleRule `json:"holes"`
+	DelayMS      int      `json:"delay_ms"` // entry delay
+}
+
+// attackTables are the garbage rules with their tables, off has none. The
+// map is only changed before the server starts.
+var attackTables = map[GarbageRule]*AttackTable{
+	// Close to what modern versus games send
+	GarbageStandard: {
+		Lines:        [5]int{0, 0, 1, 2, 4},
+		TSpin:        [4]int{0, 2, 4, 6},
+		Combo:        []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4, 5},
+		BackToBack:   1,
+		PerfectClear: 10,
+		Holes:        HolesColumn,
+		DelayMS:      500,
+	},
+	// Only line clears count and garbage is as messy as it gets
+	GarbageClassic: {
+		Lines: [5]int{0, 0, 1, 2, 4},
+		TSpin: [4]int{0, 0, 1, 2},
+		Holes: HolesRandom,
+	},
+}
+
+// LoadAttackTables reads garbage rules from a JSON file holding an object
+// of rule names and their attack tables. Rules named like the built in ones
+// replace them.
+func LoadAttackTables(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read attack tables %s: %w", path, err)
+	}
+
+	var tables map[GarbageRule]*AttackTable
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return fmt.Errorf("failed to parse attack tables %s: %w", path, err)
+	}
+	for rule, table := range tables {
+		if rule == "" || rule == GarbageOff {
+			return fmt.Errorf("attack table cannot be called %q", rule)
+		}
+		if err := table.check(); err != nil {
+			return fmt.Errorf("attack table %s: %w", rule, err)
+		}
+	}
+
+	for rule, table := range tables {
+		attackTables[rule] = table
+	}
+	return nil
+}
+
+// check makes sure the table sends no negative garbage and knows its holes
+func (t *AttackTable) check() error {
+	if t == nil {
+		return fmt.Errorf("no table given")
+	}
+	values := slices.Concat(t.Lines[:], t.TSpin[:], t.Combo, []int{t.BackToBack, t.PerfectClear, t.DelayMS})
+	for _, v := range values {
+		if v < 0 {
+			return fmt.Errorf("garbage lines and delay cannot be negative")
+		}
+	}
+	if t.Holes != HolesColumn && t.Holes != HolesRandom {
+		return fmt.Errorf("holes must be %s or %s, not %q", HolesColumn, HolesRandom, t.Holes)
+	}
+	return nil
+}
+
+// attack returns how many lines a clear sends. combo is how many clears in a
+// row came before it and backToBack says the last one was also a Tetris or
+// a T-spin.
+func (t *AttackTable) attack(lines int, tSpin bool, combo int, backToBack bool, perfect bool) int {
+	n := t.Lines[lines]
+	if tSpin {
+		n = t.TSpin[min(lines, len(t.TSpin)-1)]
+	}
+	if lines == 0 {
+		return n
+	}
+
+	if combo > 0 && len(t.Combo) > 0 {
+		n += t.Combo[min(combo, len(t.Combo)-1)]
+	}
+	if backToBack {
+		n += t.BackToBack
+	}
+	if perfect {
+		n += t.PerfectClear
+	}
+	return n
+}
+
+// incomingGarbage is one attack waiting to come in. hole is the column of
+// its holes, -1 for a column per row.
+type incomingGarbage struct {
+	lines int
+	hole  int
+	due   time.Time
+}
+
+// tradeGarbage is called as a piece locks in a match with garbage. What the
+// lock sends first cancels incoming garbage and the rest goes to the room.
+// A piece that clears nothing then lets in the garbage that is due. It
+// returns false if that pushed the stack out the top.
+func (g *game) tradeGarbage(lines int, tSpin bool) bool {
+	difficult := lines == 4 || (tSpin && lines > 0)
+	n := g.attackTable.attack(lines, tSpin, g.combo, difficult && g.backToBack, g.boardEmpty())
+	if lines > 0 {
+		g.backToBack = difficult
+	}
+
+	if n > 0 {
+		for n > 0 && len(g.incoming) > 0 {
+			canceled := min(n, g.incoming[0].lines)
+			g.incoming[0].lines -= canceled
+			n -= canceled
+			if g.incoming[0].lines == 0 {
+				g.incoming = g.incoming[1:]
+			}
+		}
+		g.countIncoming()
+	}
+
+	if n > 0 {
+		g.emit(GameEvent{Type: EventGarbageSent, Lines: n})
+		go g.room.attack(g, g.match, n)
+	}
+
+	if lines == 0 {
+		return g.letInGarbage(time.Now())
+	}
+	return true
+}
+
+// letInGarbage pushes the incoming garbage that is due in from the bottom of
+// the board. It returns false if blocks were pushed out the top.
+func (g *game) letInGarbage(now time.Time) bool {
+	lines := 0
+	fits := true
+	for len(g.incoming) > 0 && !now.Before(g.incoming[0].due) {
+		in := g.incoming[0]
+		g.incoming = g.incoming[1:]
+		for range in.lines {
+			hole := in.hole
+			if hole < 0 {
+				hole = rand.Intn(BoardWidth)
+			}
+			if !g.pushGarbageRow(hole) {
+				fits = false
+			}
+			lines++
+		}
+	}
+
+	if lines > 0 {
+		g.countIncoming()
+		g.emit(GameEvent{Type: EventGarbageReceived, Lines: lines})
+	}
+	return fits
+}
+
+// pushGarbageRow moves the board up a row and fills the bottom one but for
+// its hole. It returns false if the top row was not empty.
+func (g *game) pushGarbageRow(hole int) bool {
+	board := &g.state.Board
+	fits := board[0] == [BoardWidth]int{}
+	for y := 0; y < BoardHeight-1; y++ {
+		board[y] = board[y+1]
+	}
+	for x := range board[BoardHeight-1] {
+		board[BoardHeight-1][x] = garbageCell
+	}
+	board[BoardHeight-1][hole] = 0
+	return fits
+}
+
+// boardEmpty reports whether the last clear left nothing on the board
+func (g *game) boardEmpty() bool {
+	return g.state.Board == [BoardHeight][BoardWidth]int{}
+}
+
+// countIncoming updates the incoming garbage meter of the state
+func (g *game) countIncoming() {
+	total := 0
+	for _, in := range g.incoming {
+		total += in.lines
+	}
+	g.state.IncomingGarbage = total
+}
+
+// receiveGarbage queues lines of garbage sent by another player in the
+// given match
+func (g *game) receiveGarbage(match int, lines int) {
+	g.do(func() {
+		if g.attackTable == nil || g.match != match || g.held || g.state.GameOver {
+			return
+		}
+
+		hole := -1
+		if g.attackTable.Holes == HolesColumn {
+			hole = rand.Intn(BoardWidth)
+		}
+		delay := time.Duration(g.attackTable.DelayMS) * time.Millisecond
+		g.incoming = append(g.incoming, incomingGarbage{lines: lines, hole: hole, due: time.Now().Add(delay)})
+		g.countIncoming()
+		g.send()
+	})
+}
+
+// attack hands lines of garbage from g to another player still in the
+// given match, picked at random
+func (r *room) attack(g *game, match int, lines int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(g)
+	if i < 0 || match != r.match || r.status != RoomPlaying || !r.members[i].alive {
+		return
+	}
+
+	var targets []*roomMember
+	for _, m := range r.alive() {
+		if m.game != g {
+			targets = append(targets, m)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	targets[rand.Intn(len(targets))].game.receiveGarbage(match, lines)
+}
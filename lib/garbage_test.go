@@ -0,0 +1,223 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAttackTableAttack(t *testing.T) {
+	standard := attackTables[GarbageStandard]
+	classic := attackTables[GarbageClassic]
+
+	tests := []struct {
+		name       string
+		table      *AttackTable
+		lines      int
+		tSpin      bool
+		combo      int
+		backToBack bool
+		perfect    bool
+		want       int
+	}{
+		{"nothing cleared", standard, 0, false, 0, false, false, 0},
+		{"single", standard, 1, false, 0, false, false, 0},
+		{"double", standard, 2, false, 0, false, false, 1},
+		{"triple", standard, 3, false, 0, false, false, 2},
+		{"tetris", standard, 4, false, 0, false, false, 4},
+		{"t-spin without lines", standard, 0, true, 0, false, false, 0},
+		{"t-spin single", standard, 1, true, 0, false, false, 2},
+		{"t-spin double", standard, 2, true, 0, false, false, 4},
+		{"t-spin triple", standard, 3, true, 0, false, false, 6},
+		{"combo", standard, 2, false, 3, false, false, 1 + 2},
+		{"combo past the table", standard, 1, false, 50, false, false, 5},
+		{"back to back tetris", standard, 4, false, 0, true, false, 5},
+		{"perfect clear", standard, 1, false, 0, false, true, 10},
+		{"everything", standard, 4, false, 2, true, true, 4 + 1 + 1 + 10},
+		{"no bonus without lines", standard, 0, false, 5, true, true, 0},
+		{"classic t-spin double", classic, 2, true, 0, false, false, 1},
+		{"classic has no combo", classic, 2, false, 4, false, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.table.attack(tt.lines, tt.tSpin, tt.combo, tt.backToBack, tt.perfect)
+			if got != tt.want {
+				t.Errorf("attack = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTradeGarbage(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		incoming   []incomingGarbage
+		lines      int
+		backToBack bool
+		topRow     bool // a block in the top row, garbage pushes it out
+		wantFits   bool
+		wantSent   int
+		wantLeft   []int // lines of the attacks still incoming
+		wantIn     int   // garbage rows pushed in
+	}{
+		{
+			name:     "tetris with nothing incoming",
+			lines:    4,
+			wantFits: true,
+			wantSent: 4,
+		},
+		{
+			name:       "back to back tetris",
+			lines:      4,
+			backToBack: true,
+			wantFits:   true,
+			wantSent:   5,
+		},
+		{
+			name:     "cancels and sends the rest",
+			incoming: []incomingGarbage{{lines: 3, due: future}},
+			lines:    4,
+			wantFits: true,
+			wantSent: 1,
+		},
+		{
+			name:     "cancels oldest first",
+			incoming: []incomingGarbage{{lines: 2, due: future}, {lines: 2, due: future}},
+			lines:    3,
+			wantFits: true,
+			wantLeft: []int{2},
+		},
+		{
+			name:     "cancels part of an attack",
+			incoming: []incomingGarbage{{lines: 5, due: future}},
+			lines:    2,
+			wantFits: true,
+			wantLeft: []int{4},
+		},
+		{
+			name:     "clearing lets nothing in",
+			incoming: []incomingGarbage{{lines: 2, hole: 3, due: past}},
+			lines:    1,
+			wantFits: true,
+			wantLeft: []int{2},
+		},
+		{
+			name:     "no clear lets due garbage in",
+			incoming: []incomingGarbage{{lines: 2, hole: 3, due: past}, {lines: 1, hole: -1, due: future}},
+			wantFits: true,
+			wantLeft: []int{1},
+			wantIn:   2,
+		},
+		{
+			name:     "random holes",
+			incoming: []incomingGarbage{{lines: 3, hole: -1, due: past}},
+			wantFits: true,
+			wantIn:   3,
+		},
+		{
+			name:     "overflow",
+			incoming: []incomingGarbage{{lines: 1, hole: 0, due: past}},
+			topRow:   true,
+			wantFits: false,
+			wantIn:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A room without members takes the attack and drops it
+			g := &game{
+				attackTable: attackTables[GarbageStandard],
+				room:        &room{},
+				incoming:    slices.Clone(tt.incoming),
+				backToBack:  tt.backToBack,
+				combo:       -1,
+			}
+			// Something on the board, or every clear would be perfect
+			g.state.Board[BoardHeight-1][0] = 1
+			if tt.topRow {
+				g.state.Board[0][5] = 1
+			}
+			g.countIncoming()
+
+			fits := g.tradeGarbage(tt.lines, false)
+			if fits != tt.wantFits {
+				t.Errorf("fits = %v, want %v", fits, tt.wantFits)
+			}
+
+			sent, in := 0, 0
+			for _, ev := range g.events {
+				switch ev.Type {
+				case EventGarbageSent:
+					sent += ev.Lines
+				case EventGarbageReceived:
+					in += ev.Lines
+				}
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent %d lines, want %d", sent, tt.wantSent)
+			}
+			if in != tt.wantIn {
+				t.Errorf("let in %d lines, want %d", in, tt.wantIn)
+			}
+
+			var left []int
+			total := 0
+			for _, attack := range g.incoming {
+				left = append(left, attack.lines)
+				total += attack.lines
+			}
+			if !slices.Equal(left, tt.wantLeft) {
+				t.Errorf("incoming %v, want %v", left, tt.wantLeft)
+			}
+			if g.state.IncomingGarbage != total {
+				t.Errorf("incoming meter %d, want %d", g.state.IncomingGarbage, total)
+			}
+
+			// Garbage rows are full but for one hole
+			for y := BoardHeight - tt.wantIn; y < BoardHeight; y++ {
+				holes := 0
+				for _, cell := range g.state.Board[y] {
+					if cell == 0 {
+						holes++
+					} else if cell != garbageCell {
+						t.Fatalf("row %d has a %d, not garbage", y, cell)
+					}
+				}
+				if holes != 1 {
+					t.Errorf("row %d has %d holes, want 1", y, holes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Clients with the ack capability number their moves and every state update
+// tells them the number of the last move the server has processed. A client
+// can then show its moves straight away, and when an update arrives replay
+// only the moves newer than its ack on top of it. Moves the server drops,
+// for coming too fast for example, are acknowledged all the same so the
+// client stops replaying them.
+
+// CapAck is the capability for acknowledged moves
+const CapAck = "ack"
+
+// MoveInput is the payload of a move. Clients without the ack capability may
+// send just the direction, a bare number, which is a move without a sequence
+// number.
+type MoveInput struct {
+	Dir Direction `json:"dir"`
+	Seq uint64    `json:"seq,omitempty"`
+}
+
+// UnmarshalJSON accepts a MoveInput object or a bare direction
+func (m *MoveInput) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		*m = MoveInput{}
+		return json.Unmarshal(data, &m.Dir)
+	}
+
+	type plain MoveInput
+	return json.Unmarshal(data, (*plain)(m))
+}
+
+// ackedState is the payload of a state_update for clients with the ack
+// capability
+type ackedState struct {
+	GameState
+	Ack uint64 `json:"ack"`
+}
+
+// ackDropped acknowledges a move the input guard did not let through. The
+// ack goes out with the next update, answering every dropped move would
+// just feed a flood.
+func (g *game) ackDropped(in clientInput) {
+	if in.err != nil || in.msg.Type != Move {
+		return
+	}
+
+	var move MoveInput
+	if decodePayload(in.msg, &move) == nil && move.Seq > g.client.ack {
+		g.client.ack = move.Seq
+	}
+}
+
+// statePayload is what a state_update carries for the client
+func (g *game) statePayload(c *client) any {
+	if c.has(CapAck) {
+		return ackedState{GameState: g.state, Ack: c.ack}
+	}
+	return g.state
+}
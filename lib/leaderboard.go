@@ -0,0 +1,302 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScoreEntry is a single finished game as stored on the leaderboard
+type ScoreEntry struct {
+	Name       string    `json:"name"`
+	Mode       GameMode  `json:"mode"`
+	Score      int       `json:"score"`
+	Lines      int       `json:"lines"`
+	Level      int       `json:"level"`
+	DurationMS int64     `json:"duration_ms"`
+	Seed       int64     `json:"seed"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// Flagged says why the game looked scripted, empty for a clean game
+	Flagged string `json:"flagged,omitempty"`
+
+	// Stats are counted from the game's events, zero for games recorded
+	// before there were any
+	Stats GameStats `json:"stats"`
+}
+
+// MaxEntriesPerMode is how many games of each mode the leaderboard keeps,
+// the worst are dropped as better ones come in
+const MaxEntriesPerMode = 1000
+
+const (
+	lockRetry = 10 * time.Millisecond
+	lockWait  = 5 * time.Second
+	staleLock = time.Minute // a lock this old was left behind by a crash
+)
+
+// Leaderboard is a small on-disk store of finished games. The whole board is
+// kept in memory and rewritten to a single JSON file on every change. Writers
+// take a lock file next to it, so a server and leaderboard --prune can share
+// the file, and read it again first if someone else changed it.
+type Leaderboard struct {
+	path    string
+	mutex   sync.Mutex
+	entries []ScoreEntry
+
+	// modTime and size are the file's as we last read or wrote it
+	modTime time.Time
+	size    int64
+
+	// writes are the Add calls still saving
+	writes sync.WaitGroup
+}
+
+// OpenLeaderboard loads the leaderboard stored at path. A missing file is
+// not an error, it just means nobody has finished a game yet.
+func OpenLeaderboard(path string) (*Leaderboard, error) {
+	lb := &Leaderboard{path: path}
+	if err := lb.refresh(); err != nil {
+		return nil, err
+	}
+	return lb, nil
+}
+
+// refresh reads the file again if it changed since we last saw it. Caller
+// holds the lock.
+func (lb *Leaderboard) refresh() error {
+	info, err := os.Stat(lb.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read leaderboard %s: %w", lb.path, err)
+	}
+	if info.ModTime().Equal(lb.modTime) && info.Size() == lb.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(lb.path)
+	if err != nil {
+		return fmt.Errorf("failed to read leaderboard %s: %w", lb.path, err)
+	}
+
+	var entries []ScoreEntry
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("failed to parse leaderboard %s: %w", lb.path, err)
+		}
+	}
+
+	sortEntries(entries)
+	lb.entries = entries
+	lb.modTime = info.ModTime()
+	lb.size = info.Size()
+	return nil
+}
+
+// sortEntries orders entries best first. Ties go to whoever got there first.
+func sortEntries(entries []ScoreEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].FinishedAt.Before(entries[j].FinishedAt)
+	})
+}
+
+// lock takes the lock file every process writing the leaderboard respects and
+// returns the function that lets it go
+func (lb *Leaderboard) lock() (func(), error) {
+	path := lb.path + ".lock"
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock leaderboard %s: %w", lb.path, err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("leaderboard %s is locked, remove %s if nothing is using it", lb.path, path)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// trim keeps only the best keep entries of every mode and returns how many
+// were dropped. Caller holds the lock.
+func (lb *Leaderboard) trim(keep int) int {
+	perMode := make(map[GameMode]int)
+	kept := lb.entries[:0]
+	for _, entry := range lb.entries {
+		if perMode[entry.Mode] >= keep {
+			continue
+		}
+		perMode[entry.Mode]++
+		kept = append(kept, entry)
+	}
+
+	dropped := len(lb.entries) - len(kept)
+	lb.entries = kept
+	return dropped
+}
+
+// save writes the leaderboard to a temp file and renames it into place so a
+// crash mid-write never leaves a truncated file behind. Caller holds the lock.
+func (lb *Leaderboard) save() error {
+	data, err := json.MarshalIndent(lb.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode leaderboard: %w", err)
+	}
+
+	dir := filepath.Dir(lb.path)
+	tmp, err := os.CreateTemp(dir, ".gotris-leaderboard-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp leaderboard in %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write leaderboard: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write leaderboard: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), lb.path); err != nil {
+		return fmt.Errorf("failed to replace leaderboard %s: %w", lb.path, err)
+	}
+
+	if info, err := os.Stat(lb.path); err == nil {
+		lb.modTime = info.ModTime()
+		lb.size = info.Size()
+	}
+	return nil
+}
+
+// Record adds a finished game and persists the leaderboard
+func (lb *Leaderboard) Record(entry ScoreEntry) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	unlock, err := lb.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := lb.refresh(); err != nil {
+		return err
+	}
+	lb.entries = append(lb.entries, entry)
+	sortEntries(lb.entries)
+	lb.trim(MaxEntriesPerMode)
+	return lb.save()
+}
+
+// Add records entry in the background so games never wait on the disk.
+// Failures go to log. Flush waits for the writes still going.
+func (lb *Leaderboard) Add(entry ScoreEntry, log *slog.Logger) {
+	lb.writes.Add(1)
+	go func() {
+		defer lb.writes.Done()
+		if err := lb.Record(entry); err != nil {
+			log.Error("failed to record result", "err", err)
+		}
+	}()
+}
+
+// Flush waits for every Add to be saved
+func (lb *Leaderboard) Flush() {
+	lb.writes.Wait()
+}
+
+// Top returns the best limit entries for mode. An empty mode matches every
+// mode and a limit of zero or less returns everything.
+func (lb *Leaderboard) Top(mode GameMode, limit int) []ScoreEntry {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if err := lb.refresh(); err != nil {
+		slog.Warn("keeping the leaderboard we have", "err", err)
+	}
+
+	top := []ScoreEntry{}
+	for _, entry := range lb.entries {
+		if mode != "" && entry.Mode != mode {
+			continue
+		}
+		top = append(top, entry)
+		if limit > 0 && len(top) == limit {
+			break
+		}
+	}
+	return top
+}
+
+// Prune keeps only the best keep entries of every mode and returns how many
+// entries were dropped.
+func (lb *Leaderboard) Prune(keep int) (int, error) {
+	if keep < 0 {
+		return 0, fmt.Errorf("Prune must keep at least 0 entries not %d", keep)
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	unlock, err := lb.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := lb.refresh(); err != nil {
+		return 0, err
+	}
+
+	dropped := lb.trim(keep)
+	if dropped == 0 {
+		return 0, nil
+	}
+	return dropped, lb.save()
+}
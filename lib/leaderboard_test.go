@@ -0,0 +1,195 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testLeaderboard opens a leaderboard in a fresh directory and records
+// entries in it
+func testLeaderboard(t *testing.T, entries ...ScoreEntry) *Leaderboard {
+	t.Helper()
+	lb, err := OpenLeaderboard(filepath.Join(t.TempDir(), "leaderboard.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := lb.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lb
+}
+
+func names(entries []ScoreEntry) []string {
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// sprint stands in for another mode, the board keeps any it is given
+const sprint GameMode = "sprint"
+
+var boardEntries = func() []ScoreEntry {
+	at := time.Date(2025, 4, 18, 20, 0, 0, 0, time.UTC)
+	entry := func(name string, mode GameMode, score int, minutes int) ScoreEntry {
+		return ScoreEntry{Name: name, Mode: mode, Score: score, FinishedAt: at.Add(time.Duration(minutes) * time.Minute)}
+	}
+	return []ScoreEntry{
+		entry("ann", Marathon, 500, 0),
+		entry("bob", Marathon, 900, 1),
+		entry("cat", sprint, 700, 2),
+		entry("dan", Marathon, 500, -1), // ties with ann but got there first
+		entry("eve", Versus, 300, 3),
+		entry("fay", sprint, 100, 4),
+	}
+}()
+
+func TestLeaderboardTop(t *testing.T) {
+	lb := testLeaderboard(t, boardEntries...)
+
+	tests := []struct {
+		name  string
+		mode  GameMode
+		limit int
+		want  []string
+	}{
+		{"everything", "", 0, []string{"bob", "cat", "dan", "ann", "eve", "fay"}},
+		{"limit", "", 2, []string{"bob", "cat"}},
+		{"mode", Marathon, 0, []string{"bob", "dan", "ann"}},
+		{"mode and limit", sprint, 1, []string{"cat"}},
+		{"limit past the end", Versus, 5, []string{"eve"}},
+		{"nobody played", GameMode("ultra"), 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(lb.Top(tt.mode, tt.limit)); !slices.Equal(got, tt.want) {
+				t.Errorf("Top(%q, %d) = %v, want %v", tt.mode, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaderboardPrune(t *testing.T) {
+	tests := []struct {
+		name        string
+		keep        int
+		wantDropped int
+		want        []string
+		wantErr     bool
+	}{
+		{"keep all", 10, 0, []string{"bob", "cat", "dan", "ann", "eve", "fay"}, false},
+		{"best of each mode", 1, 3, []string{"bob", "cat", "eve"}, false},
+		{"two of each mode", 2, 1, []string{"bob", "cat", "dan", "eve", "fay"}, false},
+		{"nothing", 0, 6, nil, false},
+		{"negative", -1, 0, []string{"bob", "cat", "dan", "ann", "eve", "fay"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := testLeaderboard(t, boardEntries...)
+
+			dropped, err := lb.Prune(tt.keep)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("dropped %d, want %d", dropped, tt.wantDropped)
+			}
+			if got := names(lb.Top("", 0)); !slices.Equal(got, tt.want) {
+				t.Errorf("left %v, want %v", got, tt.want)
+			}
+
+			// The file has it too
+			reopened, err := OpenLeaderboard(lb.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(reopened.Top("", 0)); !slices.Equal(got, tt.want) {
+				t.Errorf("file has %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaderboardSharedFile(t *testing.T) {
+	server := testLeaderboard(t, boardEntries...)
+	prune, err := OpenLeaderboard(server.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := prune.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Record(ScoreEntry{Name: "gus", Mode: Marathon, Score: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record went on top of the pruned board, not the old one
+	want := []string{"bob", "cat", "eve", "gus"}
+	if got := names(prune.Top("", 0)); !slices.Equal(got, want) {
+		t.Errorf("board is %v, want %v", got, want)
+	}
+	if _, err := os.Stat(server.path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestLeaderboardRecordCaps(t *testing.T) {
+	lb := testLeaderboard(t)
+	for i := range MaxEntriesPerMode {
+		lb.entries = append(lb.entries, ScoreEntry{Name: "old", Mode: Marathon, Score: MaxEntriesPerMode - i})
+	}
+
+	if err := lb.Record(ScoreEntry{Name: "new", Mode: Marathon, Score: 10 * MaxEntriesPerMode}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lb.Record(ScoreEntry{Name: "sprinter", Mode: sprint, Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	marathon := lb.Top(Marathon, 0)
+	if len(marathon) != MaxEntriesPerMode {
+		t.Fatalf("kept %d marathon games, want %d", len(marathon), MaxEntriesPerMode)
+	}
+	if marathon[0].Name != "new" || marathon[len(marathon)-1].Score != 2 {
+		t.Errorf("kept %s first and a score of %d last, want new and 2",
+			marathon[0].Name, marathon[len(marathon)-1].Score)
+	}
+	if len(lb.Top(sprint, 0)) != 1 {
+		t.Errorf("sprint game dropped")
+	}
+}
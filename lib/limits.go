@@ -0,0 +1,143 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection limit defaults. Message size and read timeout fall back to
+// theirs when the server config leaves them unset, the others are the
+// flag defaults since zero turns them off.
+const (
+	DefaultMaxSessions    = 100
+	DefaultMaxConnsPerIP  = 10
+	DefaultMaxMessageSize = 4096
+	DefaultReadTimeout    = 60 * time.Second
+	DefaultPingInterval   = 20 * time.Second
+)
+
+// connLimits are the limits put on websocket connections
+type connLimits struct {
+	// allowedOrigins are the browser origins allowed to open a websocket.
+	// Empty means only pages served by this server, "*" allows anyone.
+	allowedOrigins []string
+
+	maxSessions    int           // sessions registered at once, 0 for no cap
+	maxMessageSize int64         // largest message a client may send
+	readTimeout    time.Duration // longest a client may go without a message or pong
+	pingInterval   time.Duration // how often clients are pinged, 0 for never
+
+	perIP *ipLimiter
+}
+
+var limits = connLimits{
+	maxSessions:    DefaultMaxSessions,
+	maxMessageSize: DefaultMaxMessageSize,
+	readTimeout:    DefaultReadTimeout,
+	pingInterval:   DefaultPingInterval,
+	perIP:          newIPLimiter(DefaultMaxConnsPerIP),
+}
+
+// checkOrigin is the upgrader's CheckOrigin. Requests without an Origin
+// header do not come from a browser and are let through, as gorilla does.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(limits.allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range limits.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipLimiter caps the number of open connections from one address. Its max
+// caps the sessions an address has registered as well, see sessionManager.
+type ipLimiter struct {
+	max   int // 0 for no cap
+	mutex sync.Mutex
+	conns map[string]int
+}
+
+func newIPLimiter(max int) *ipLimiter {
+	return &ipLimiter{
+		max:   max,
+		conns: make(map[string]int),
+	}
+}
+
+// remoteIP is the address part of a request's RemoteAddr
+func remoteIP(r *http.Request) string {
+	return addrIP(r.RemoteAddr)
+}
+
+// addrIP is the address part of a host:port
+func addrIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Unix sockets have no port, everyone shares one bucket
+		return addr
+	}
+	return host
+}
+
+// acquire takes a connection slot for ip, reporting false if it has none left
+func (l *ipLimiter) acquire(ip string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.max > 0 && l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+// release gives back a slot taken by acquire
+func (l *ipLimiter) release(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
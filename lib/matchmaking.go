@@ -0,0 +1,277 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// The matchmaking queue puts players who asked for a match of a given size
+// together in a room of their own and starts it. With ratings turned on
+// players are only matched with players of about their rating, a window
+// that widens the longer they wait. Whoever is still waiting after botAfter
+// is matched with whoever else is waiting and bots for the empty seats.
+//
+// The queue belongs to the session manager's run goroutine. Players join it
+// through readySessions and leave it through leftQueue.
+
+// Matchmaking defaults
+const (
+	DefaultMatchBotAfter = 30 * time.Second
+
+	matchInterval = time.Second
+
+	initialRating      = 1000
+	ratingK            = 32 // most a rating moves in a two player match
+	ratingWindow       = 100
+	ratingWindowGrowth = 20 // rating points a second the window widens by
+)
+
+var errQueueInRoom = errors.New("leave your room before looking for a match")
+
+// QueueRequest is the payload of queue_join
+type QueueRequest struct {
+	Players int `json:"players"` // match size, two for a 1v1
+}
+
+// QueueStatus is the payload of queue, sent to players in the queue once a
+// second. A null payload means the player is no longer waiting, either
+// because they left or because they got a match and a room message follows.
+type QueueStatus struct {
+	Players       int `json:"players"`
+	Waiting       int `json:"waiting"` // players in the queue for this size
+	WaitedSeconds int `json:"waited_seconds"`
+	Rating        int `json:"rating,omitempty"` // with ratings turned on
+}
+
+// matchTicket is a player waiting for a match
+type matchTicket struct {
+	game    *game
+	players int
+	rating  float64
+	since   time.Time
+}
+
+// matchmaker is the queue, owned by the session manager's run goroutine
+type matchmaker struct {
+	waiting []*matchTicket // oldest first
+
+	rated    bool          // match players by rating
+	botAfter time.Duration // zero never brings in bots
+}
+
+// enqueue puts g's player in the queue, or changes the match size it waits
+// for
+func (m *sessionManager) enqueue(g *game, request QueueRequest) error {
+	if rooms.roomOf(g) != nil {
+		return errQueueInRoom
+	}
+
+	m.readySessions <- &matchTicket{
+		game:    g,
+		players: request.Players,
+		rating:  ratings.get(g.id),
+		since:   time.Now(),
+	}
+	return nil
+}
+
+// dequeue takes g's player out of the queue, if it is in it
+func (m *sessionManager) dequeue(g *game) {
+	m.leftQueue <- g
+}
+
+// add puts t in the queue in place of any ticket its player had
+func (mm *matchmaker) add(t *matchTicket) {
+	mm.drop(t.game)
+	mm.waiting = append(mm.waiting, t)
+	t.game.log.Info("looking for a match", "players", t.players, "rating", math.Round(t.rating))
+	mm.tell(time.Now())
+}
+
+// remove takes g's ticket out of the queue and tells the player
+func (mm *matchmaker) remove(g *game) {
+	if mm.drop(g) {
+		g.notify(QueueMsg, (*QueueStatus)(nil))
+	}
+}
+
+// has reports whether g's player is in the queue
+func (mm *matchmaker) has(g *game) bool {
+	for _, t := range mm.waiting {
+		if t.game == g {
+			return true
+		}
+	}
+	return false
+}
+
+// drop takes g's ticket out of the queue, reporting whether it had one
+func (mm *matchmaker) drop(g *game) bool {
+	for i, t := range mm.waiting {
+		if t.game == g {
+			mm.waiting = append(mm.waiting[:i], mm.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pair starts every match it can make out of the queue, oldest tickets
+// first, then tells those still waiting how it is going
+func (mm *matchmaker) pair(now time.Time) {
+	matched := make(map[*matchTicket]bool)
+	for i, t := range mm.waiting {
+		if matched[t] {
+			continue
+		}
+
+		group := []*matchTicket{t}
+		window := ratingWindow + ratingWindowGrowth*now.Sub(t.since).Seconds()
+		for _, other := range mm.waiting[i+1:] {
+			if len(group) == t.players {
+				break
+			}
+			if matched[other] || other.players != t.players {
+				continue
+			}
+			if mm.rated && math.Abs(other.rating-t.rating) > window {
+				continue
+			}
+			group = append(group, other)
+		}
+
+		bots := 0
+		if len(group) < t.players {
+			if mm.botAfter <= 0 || now.Sub(t.since) < mm.botAfter {
+				continue
+			}
+			bots = t.players - len(group)
+		}
+
+		games := make([]*game, len(group))
+		for j, member := range group {
+			matched[member] = true
+			games[j] = member.game
+			member.game.notify(QueueMsg, (*QueueStatus)(nil))
+		}
+		// Starting the match waits on every game, the queue must not
+		go rooms.matchmade(games, bots, mm.rated)
+	}
+
+	waiting := mm.waiting[:0]
+	for _, t := range mm.waiting {
+		if !matched[t] {
+			waiting = append(waiting, t)
+		}
+	}
+	clear(mm.waiting[len(waiting):])
+	mm.waiting = waiting
+	mm.tell(now)
+}
+
+// tell sends everybody in the queue where they are
+func (mm *matchmaker) tell(now time.Time) {
+	sizes := make(map[int]int)
+	for _, t := range mm.waiting {
+		sizes[t.players]++
+	}
+
+	for _, t := range mm.waiting {
+		status := &QueueStatus{
+			Players:       t.players,
+			Waiting:       sizes[t.players],
+			WaitedSeconds: int(now.Sub(t.since).Seconds()),
+		}
+		if mm.rated {
+			status.Rating = int(math.Round(t.rating))
+		}
+		t.game.notify(QueueMsg, status)
+	}
+}
+
+// ratingTable keeps an Elo rating for every session for as long as the
+// server runs. Ratings go by session id rather than the name a player
+// types in, which anybody could pick to take over someone else's rating.
+type ratingTable struct {
+	mutex     sync.Mutex
+	bySession map[string]float64
+}
+
+var ratings = ratingTable{bySession: make(map[string]float64)}
+
+// get returns the rating of the player with the given session id
+func (rt *ratingTable) get(id string) float64 {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	if rating, ok := rt.bySession[id]; ok {
+		return rating
+	}
+	return initialRating
+}
+
+// update rates a match between the players with the given session ids,
+// best first. Every player counts as having beaten everybody behind them
+// and the changes are scaled down so a match moves a rating about as much
+// as a 1v1 would.
+func (rt *ratingTable) update(ids []string) {
+	if len(ids) < 2 {
+		return
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	old := make([]float64, len(ids))
+	for i, id := range ids {
+		old[i] = initialRating
+		if rating, ok := rt.bySession[id]; ok {
+			old[i] = rating
+		}
+	}
+
+	k := ratingK / float64(len(ids)-1)
+	for i, id := range ids {
+		change := 0.0
+		for j := range ids {
+			if i == j {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, (old[j]-old[i])/400))
+			won := 0.0
+			if i < j {
+				won = 1
+			}
+			change += k * (won - expected)
+		}
+		rt.bySession[id] = old[i] + change
+	}
+}
@@ -0,0 +1,181 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"math"
+	"testing"
+)
+
+// closeTo reports whether two ratings are the same, give or take rounding
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestRatingTableUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string]float64
+		ids    []string // best first
+		want   map[string]float64
+	}{
+		{
+			name: "one player is not a match",
+			ids:  []string{"a"},
+			want: map[string]float64{},
+		},
+		{
+			name: "1v1 between new players",
+			ids:  []string{"a", "b"},
+			want: map[string]float64{"a": initialRating + ratingK/2, "b": initialRating - ratingK/2},
+		},
+		{
+			name: "three new players",
+			ids:  []string{"a", "b", "c"},
+			want: map[string]float64{"a": initialRating + ratingK/2, "b": initialRating, "c": initialRating - ratingK/2},
+		},
+		{
+			name:   "favourite wins",
+			before: map[string]float64{"a": 1400, "b": 1000},
+			ids:    []string{"a", "b"},
+			want:   map[string]float64{"a": 1400 + ratingK/11.0, "b": 1000 - ratingK/11.0},
+		},
+		{
+			name:   "upset",
+			before: map[string]float64{"a": 1400, "b": 1000},
+			ids:    []string{"b", "a"},
+			want:   map[string]float64{"a": 1400 - ratingK*10/11.0, "b": 1000 + ratingK*10/11.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := ratingTable{bySession: make(map[string]float64)}
+			for id, rating := range tt.before {
+				rt.bySession[id] = rating
+			}
+
+			rt.update(tt.ids)
+
+			if len(rt.bySession) != len(tt.want) {
+				t.Fatalf("ratings %v, want %v", rt.bySession, tt.want)
+			}
+			for id, want := range tt.want {
+				if got := rt.get(id); !closeTo(got, want) {
+					t.Errorf("rating of %s = %.3f, want %.3f", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRatingTableUpdateKeepsTotal(t *testing.T) {
+	rt := ratingTable{bySession: map[string]float64{"a": 1210, "b": 990, "c": 1500, "d": 870}}
+	ids := []string{"d", "a", "c", "b"}
+
+	total := 0.0
+	for _, id := range ids {
+		total += rt.get(id)
+	}
+	rt.update(ids)
+	after := 0.0
+	for _, id := range ids {
+		after += rt.get(id)
+	}
+	if !closeTo(total, after) {
+		t.Errorf("ratings add up to %.3f after the match, %.3f before", after, total)
+	}
+}
+
+func TestRoomRate(t *testing.T) {
+	member := func(id string, place int) *roomMember {
+		return &roomMember{game: &game{id: id}, place: place}
+	}
+	bot := member("bot", 1)
+	bot.bot = true
+
+	tests := []struct {
+		name     string
+		members  []*roomMember
+		quitters []*roomMember
+		order    []string // best rating first
+		unrated  []string
+	}{
+		{
+			name:    "places",
+			members: []*roomMember{member("b", 2), member("a", 1), member("c", 3)},
+			order:   []string{"a", "b", "c"},
+		},
+		{
+			name:     "quitter finished last",
+			members:  []*roomMember{member("a", 1)},
+			quitters: []*roomMember{member("q", 2)},
+			order:    []string{"a", "q"},
+		},
+		{
+			name:     "quitter ahead of the last player",
+			members:  []*roomMember{member("a", 1), member("c", 3)},
+			quitters: []*roomMember{member("q", 2)},
+			order:    []string{"a", "q", "c"},
+		},
+		{
+			name:    "bots are not rated",
+			members: []*roomMember{bot, member("a", 2), member("b", 3)},
+			order:   []string{"a", "b"},
+			unrated: []string{"bot"},
+		},
+		{
+			name:    "players without a place are not rated",
+			members: []*roomMember{member("a", 1), member("b", 0)},
+			unrated: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := ratings.bySession
+			ratings.bySession = make(map[string]float64)
+			t.Cleanup(func() { ratings.bySession = saved })
+
+			r := &room{members: tt.members, quitters: tt.quitters}
+			r.rate()
+
+			for i := 1; i < len(tt.order); i++ {
+				better, worse := tt.order[i-1], tt.order[i]
+				if ratings.get(better) <= ratings.get(worse) {
+					t.Errorf("%s rated %.1f, not above %s at %.1f",
+						better, ratings.get(better), worse, ratings.get(worse))
+				}
+			}
+			for _, id := range tt.unrated {
+				if _, ok := ratings.bySession[id]; ok {
+					t.Errorf("%s was rated", id)
+				}
+			}
+		})
+	}
+}
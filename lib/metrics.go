@@ -0,0 +1,240 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// counterVec is a Prometheus counter split by the value of one label. An
+// empty label name makes it a plain counter.
+type counterVec struct {
+	name   string
+	help   string
+	label  string
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValue string) {
+	c.mutex.Lock()
+	c.values[labelValue]++
+	c.mutex.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.values[""]))
+		return
+	}
+	for _, value := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, labelPair(c.label, value), formatFloat(c.values[value]))
+	}
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a Prometheus histogram split by the value of one label.
+// An empty label name makes it a plain histogram.
+type histogramVec struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64 // upper bounds in increasing order, +Inf is implied
+	mutex   sync.Mutex
+	series  map[string]*histogram
+}
+
+func newHistogramVec(name string, help string, label string, buckets []float64) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) observe(labelValue string, value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, value := range sortedKeys(h.series) {
+		s := h.series[value]
+		labels := ""
+		if h.label != "" {
+			labels = labelPair(h.label, value) + ","
+		}
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, labels, s.count)
+
+		labels = strings.TrimSuffix(labels, ",")
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+// serverMetrics are the numbers served on /metrics
+type serverMetrics struct {
+	gamesStarted     *counterVec
+	gamesFinished    *counterVec
+	scores           *histogramVec
+	messagesReceived *counterVec
+	messagesSent     *counterVec
+	sendErrors       *counterVec
+	tickLag          *histogramVec
+	gameEvents       *counterVec
+}
+
+var metrics = serverMetrics{
+	gamesStarted: newCounterVec("gotris_games_started_total",
+		"Games started, including restarts.", "mode"),
+	gamesFinished: newCounterVec("gotris_games_finished_total",
+		"Games played until the stack topped out.", "mode"),
+	scores: newHistogramVec("gotris_game_score",
+		"Final score of finished games.", "mode",
+		[]float64{100, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000}),
+	messagesReceived: newCounterVec("gotris_websocket_messages_received_total",
+		"Websocket messages read from clients by message type.", "type"),
+	messagesSent: newCounterVec("gotris_websocket_messages_sent_total",
+		"Websocket messages written to clients by message type.", "type"),
+	sendErrors: newCounterVec("gotris_send_errors_total",
+		"Messages that could not be written to a client.", ""),
+	tickLag: newHistogramVec("gotris_tick_lag_seconds",
+		"How late game loops handle their gravity ticks.", "",
+		[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}),
+	gameEvents: newCounterVec("gotris_game_events_total",
+		"Game events by type.", "type"),
+}
+
+// handleMetrics serves the metrics in the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	registered, connected := registry.counts()
+	writeHeader(w, "gotris_sessions", "Sessions on the server by whether a client is connected.", "gauge")
+	fmt.Fprintf(w, "gotris_sessions{%s} %d\n", labelPair("state", "connected"), connected)
+	fmt.Fprintf(w, "gotris_sessions{%s} %d\n", labelPair("state", "waiting"), registered-connected)
+
+	metrics.gamesStarted.write(w)
+	metrics.gamesFinished.write(w)
+	metrics.scores.write(w)
+	metrics.messagesReceived.write(w)
+	metrics.messagesSent.write(w)
+	metrics.sendErrors.write(w)
+	metrics.tickLag.write(w)
+	metrics.gameEvents.write(w)
+}
+
+// receivedTypeLabel keeps clients from making up label values, every type
+// the server does not know about is counted as unknown
+func receivedTypeLabel(msgType MessageType) string {
+	switch msgType {
+	case Move, NewGame, Hello, Resync:
+		return string(msgType)
+	}
+	if isLobbyMessage(msgType) {
+		return string(msgType)
+	}
+	return "unknown"
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// labelValueEscaper escapes label values as the exposition format asks
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelPair(name string, value string) string {
+	return fmt.Sprintf("%s=\"%s\"", name, labelValueEscaper.Replace(value))
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
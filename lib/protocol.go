@@ -0,0 +1,122 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Protocol versions the server speaks. A client names the versions it speaks
+// in its hello and the server picks the newest one both know. Clients that
+// never say hello get version 1 without any capabilities.
+const (
+	ProtocolVersion    = 2
+	minProtocolVersion = 1
+)
+
+// versionGarbage is the version that added the incoming garbage meter to
+// binary states and deltas
+const versionGarbage = 2
+
+// serverCapabilities are the optional protocol features the server offers.
+// A client gets the ones it asks for in its hello.
+var serverCapabilities = []string{CapDelta, CapBinary, CapEvents, CapAck}
+
+// ClientHello is the payload of the hello a client sends as its first message
+type ClientHello struct {
+	Versions     []int    `json:"versions"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Client       string   `json:"client,omitempty"` // free form, for the logs
+}
+
+// ServerHello answers a ClientHello with what the connection will use
+type ServerHello struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+	Server       string   `json:"server"`
+}
+
+// ErrorCode says what was wrong with a message a client sent
+type ErrorCode string
+
+const (
+	ErrMalformed          ErrorCode = "malformed"           // not a message at all
+	ErrUnknownType        ErrorCode = "unknown_type"        // a message type the server does not know
+	ErrBadPayload         ErrorCode = "bad_payload"         // the payload does not fit the message type
+	ErrUnsupportedVersion ErrorCode = "unsupported_version" // no protocol version in common
+)
+
+// ErrorNotice is the payload of an error message. Type is the type of the
+// message that caused it, if it got that far.
+type ErrorNotice struct {
+	Code    ErrorCode   `json:"code"`
+	Type    MessageType `json:"type,omitempty"`
+	Message string      `json:"message"`
+}
+
+// serverName is sent in the server's hello
+const serverName = "gotris"
+
+// negotiate picks the protocol version and capabilities for a client. It
+// returns false if the client speaks no version the server does.
+func negotiate(hello ClientHello) (ServerHello, bool) {
+	reply := ServerHello{Capabilities: []string{}, Server: serverName}
+	for _, v := range hello.Versions {
+		if v >= minProtocolVersion && v <= ProtocolVersion && v > reply.Version {
+			reply.Version = v
+		}
+	}
+	if reply.Version == 0 {
+		return reply, false
+	}
+
+	for _, capability := range hello.Capabilities {
+		if slices.Contains(serverCapabilities, capability) && !slices.Contains(reply.Capabilities, capability) {
+			reply.Capabilities = append(reply.Capabilities, capability)
+		}
+	}
+	return reply, true
+}
+
+// has reports whether the client agreed to use capability
+func (c *client) has(capability string) bool {
+	return slices.Contains(c.capabilities, capability)
+}
+
+// decodePayload unpacks the payload of msg into v, which has to be the
+// payload type of msg's message type
+func decodePayload(msg Message, v any) error {
+	if len(msg.Payload) == 0 {
+		return fmt.Errorf("%s needs a payload", msg.Type)
+	}
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		return fmt.Errorf("bad %s payload: %w", msg.Type, err)
+	}
+	return nil
+}
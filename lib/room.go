@@ -0,0 +1,916 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rooms are where players meet for a match. A room is found by its code and
+// run by its host, the member who has been in it the longest, who picks the
+// settings and starts a match once everybody is ready. Matches are played
+// on the members' own games: in the lobby a member's game is held still,
+// and a match restarts every game with the room's rules and a shared seed so
+// everybody gets the same pieces. The last player standing wins.
+//
+// Room and queue messages are served on the player's reader goroutine rather
+// than its game goroutine, since they reach into the games of the other
+// members.
+
+// Room limits
+const (
+	maxRoomPlayers     = 8
+	DefaultRoomPlayers = 2 // how many players fit in a new room unless the server says otherwise
+
+	roomCodeLen      = 5
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O or 1/I
+)
+
+// GarbageRule decides what clearing lines sends to the other players. It
+// names an attack table, see attackTables.
+type GarbageRule string
+
+const (
+	// GarbageOff sends nothing, every player plays their own game
+	GarbageOff GarbageRule = "off"
+)
+
+// ParseGarbageRule checks that name is a garbage rule we know
+func ParseGarbageRule(name string) (GarbageRule, error) {
+	rule := GarbageRule(name)
+	if _, ok := attackTables[rule]; ok || rule == GarbageOff {
+		return rule, nil
+	}
+	return "", fmt.Errorf("unknown garbage rule %q", name)
+}
+
+// RoomStatus is where a room is between its matches
+type RoomStatus string
+
+const (
+	RoomLobby    RoomStatus = "lobby"    // waiting for the first match
+	RoomPlaying  RoomStatus = "playing"  // a match is on
+	RoomFinished RoomStatus = "finished" // the last match is over
+)
+
+// ErrRoom is sent for room messages that cannot be done, the message says
+// why
+const ErrRoom ErrorCode = "room"
+
+var (
+	errNotInRoom     = errors.New("not in a room")
+	errInRoom        = errors.New("already in a room, leave it first")
+	errNoSuchRoom    = errors.New("no room with that code")
+	errRoomFull      = errors.New("the room is full")
+	errNotHost       = errors.New("only the host can do that")
+	errMatchRunning  = errors.New("a match is being played")
+	errNotReady      = errors.New("not every player is ready")
+	errRoomNewGame   = errors.New("the host starts new games in a room")
+	errTooManyInRoom = errors.New("there are more players in the room than that")
+)
+
+// RoomSettings are what the host picks for the room's matches. Fields left
+// out of a room_create or room_settings message keep their value.
+type RoomSettings struct {
+	Mode       GameMode    `json:"mode,omitempty"`
+	MaxPlayers int         `json:"max_players,omitempty"`
+	Garbage    GarbageRule `json:"garbage,omitempty"`
+}
+
+// apply returns s changed by the fields set in change
+func (s RoomSettings) apply(change RoomSettings) (RoomSettings, error) {
+	if change.Mode != "" {
+		mode, err := ParseGameMode(string(change.Mode))
+		if err != nil {
+			return s, err
+		}
+		s.Mode = mode
+	}
+	if change.MaxPlayers != 0 {
+		if change.MaxPlayers < 1 || change.MaxPlayers > maxRoomPlayers {
+			return s, fmt.Errorf("max players must be between 1 and %d", maxRoomPlayers)
+		}
+		s.MaxPlayers = change.MaxPlayers
+	}
+	if change.Garbage != "" {
+		rule, err := ParseGarbageRule(string(change.Garbage))
+		if err != nil {
+			return s, err
+		}
+		s.Garbage = rule
+	}
+	return s, nil
+}
+
+// RoomJoinRequest is the payload of room_join
+type RoomJoinRequest struct {
+	Code string `json:"code"`
+}
+
+// RoomReadyRequest is the payload of room_ready
+type RoomReadyRequest struct {
+	Ready bool `json:"ready"`
+}
+
+// RoomState is the payload of room, sent to every member whenever the room
+// changes. A null payload means the player is no longer in a room.
+type RoomState struct {
+	Code     string       `json:"code"`
+	Status   RoomStatus   `json:"status"`
+	Settings RoomSettings `json:"settings"`
+	Players  []RoomPlayer `json:"players"`
+}
+
+// RoomPlayer is one member as the room sees it. The watch id opens the
+// player's board on /ws/watch.
+type RoomPlayer struct {
+	Name    string `json:"name"`
+	WatchID string `json:"watch_id"`
+	You     bool   `json:"you,omitempty"`
+	Host    bool   `json:"host,omitempty"`
+	Ready   bool   `json:"ready"`
+	Bot     bool   `json:"bot,omitempty"`
+	Alive   bool   `json:"alive"`           // still in the current match
+	Place   int    `json:"place,omitempty"` // where the player finished the last match
+	Score   int    `json:"score"`
+}
+
+// RoomSummary is a room as listed on /api/rooms
+type RoomSummary struct {
+	Code       string      `json:"code"`
+	Host       string      `json:"host"`
+	Status     RoomStatus  `json:"status"`
+	Mode       GameMode    `json:"mode"`
+	Garbage    GarbageRule `json:"garbage"`
+	Players    int         `json:"players"`
+	MaxPlayers int         `json:"max_players"`
+}
+
+// room is a group of players and their matches. Its mutex is held for
+// everything done to it, including the calls into the members' games.
+// Games never wait for a room, they hand it their top outs on a goroutine
+// of their own.
+type room struct {
+	code  string
+	mutex sync.Mutex
+
+	settings RoomSettings
+	status   RoomStatus
+	members  []*roomMember // in the order they joined
+	match    int           // numbers the matches so stale top outs are ignored
+	starters int           // how many players the match started with
+	rated    bool          // matches change the players' ratings, see ratingTable
+	quitters []*roomMember // left the match being played, they still get rated
+	closed   bool          // the last player left
+}
+
+type roomMember struct {
+	game  *game
+	bot   bool // played by the server, always ready
+	ready bool
+	alive bool
+	place int
+	score int // final score of the member's last match
+}
+
+// roomManager finds rooms by code and by member. Its mutex only guards the
+// maps and is never held while waiting for a room.
+type roomManager struct {
+	mutex  sync.Mutex
+	rooms  map[string]*room
+	byGame map[*game]*room
+}
+
+var rooms = roomManager{
+	rooms:  make(map[string]*room),
+	byGame: make(map[*game]*room),
+}
+
+// isLobbyMessage reports whether msgType is served by the rooms and the
+// matchmaking queue rather than the player's game
+func isLobbyMessage(msgType MessageType) bool {
+	switch msgType {
+	case RoomCreate, RoomJoin, RoomLeave, RoomReady, RoomConfigure, RoomStart, RoomRestart,
+		QueueJoin, QueueLeave:
+		return true
+	}
+	return false
+}
+
+// handle serves a room message from c, the player of g, answering it with an
+// error if it cannot be done
+func (rm *roomManager) handle(g *game, c *client, msg Message) {
+	if !g.allowInput(c) {
+		return
+	}
+
+	var err error
+	var badPayload error
+	switch msg.Type {
+	case RoomCreate:
+		var settings RoomSettings
+		if len(msg.Payload) > 0 {
+			badPayload = checkSettings(msg, &settings)
+		}
+		if badPayload == nil {
+			err = rm.create(g, settings)
+		}
+		if err == nil && badPayload == nil {
+			registry.dequeue(g)
+		}
+
+	case RoomJoin:
+		var join RoomJoinRequest
+		if badPayload = decodePayload(msg, &join); badPayload == nil {
+			err = rm.join(g, join.Code)
+		}
+		if err == nil && badPayload == nil {
+			registry.dequeue(g)
+		}
+
+	case RoomLeave:
+		err = rm.leave(g, true)
+
+	case RoomReady:
+		var ready RoomReadyRequest
+		if badPayload = decodePayload(msg, &ready); badPayload == nil {
+			err = rm.ready(g, ready.Ready)
+		}
+
+	case RoomConfigure:
+		var settings RoomSettings
+		if badPayload = checkSettings(msg, &settings); badPayload == nil {
+			err = rm.configure(g, settings)
+		}
+
+	case RoomStart:
+		err = rm.start(g, false)
+
+	case RoomRestart:
+		err = rm.start(g, true)
+
+	case QueueJoin:
+		request := QueueRequest{Players: DefaultRoomPlayers}
+		if len(msg.Payload) > 0 {
+			badPayload = decodePayload(msg, &request)
+		}
+		if badPayload == nil && (request.Players < 2 || request.Players > maxRoomPlayers) {
+			badPayload = fmt.Errorf("matches are for 2 to %d players", maxRoomPlayers)
+		}
+		if badPayload == nil {
+			err = registry.enqueue(g, request)
+		}
+
+	case QueueLeave:
+		registry.dequeue(g)
+	}
+
+	switch {
+	case badPayload != nil:
+		g.reject(c, ErrorNotice{Code: ErrBadPayload, Type: msg.Type, Message: badPayload.Error()})
+	case err != nil:
+		g.reject(c, ErrorNotice{Code: ErrRoom, Type: msg.Type, Message: err.Error()})
+	}
+}
+
+// checkSettings decodes the settings in msg and checks the values make sense
+func checkSettings(msg Message, settings *RoomSettings) error {
+	if err := decodePayload(msg, settings); err != nil {
+		return err
+	}
+	_, err := RoomSettings{}.apply(*settings)
+	return err
+}
+
+// roomOf returns the room g is in, nil if it is in none
+func (rm *roomManager) roomOf(g *game) *room {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	return rm.byGame[g]
+}
+
+// defaultRoomSettings are the settings of a new room
+func defaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		Mode:       registry.rules.Mode,
+		MaxPlayers: registry.roomPlayers,
+		Garbage:    GarbageStandard,
+	}
+}
+
+// create opens a new room with g as its host
+func (rm *roomManager) create(g *game, change RoomSettings) error {
+	settings, err := defaultRoomSettings().apply(change)
+	if err != nil {
+		return err
+	}
+
+	r := &room{
+		settings: settings,
+		status:   RoomLobby,
+		members:  []*roomMember{{game: g}},
+	}
+
+	rm.mutex.Lock()
+	if rm.byGame[g] != nil {
+		rm.mutex.Unlock()
+		return errInRoom
+	}
+	r.code = rm.newCode()
+	rm.rooms[r.code] = r
+	rm.byGame[g] = r
+	rm.mutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g.enterRoom(r)
+	g.log.Info("room created", "room", r.code, "settings", settings)
+	r.broadcast()
+	return nil
+}
+
+// newCode picks a code no other room has. Caller holds the lock.
+func (rm *roomManager) newCode() string {
+	for {
+		b := make([]byte, roomCodeLen)
+		rand.Read(b)
+		for i := range b {
+			b[i] = roomCodeAlphabet[int(b[i])%len(roomCodeAlphabet)]
+		}
+		if _, taken := rm.rooms[string(b)]; !taken {
+			return string(b)
+		}
+	}
+}
+
+// join adds g to the room with the given code. Rooms cannot be joined in
+// the middle of a match.
+func (rm *roomManager) join(g *game, code string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	rm.mutex.Lock()
+	r, ok := rm.rooms[code]
+	inRoom := rm.byGame[g] != nil
+	rm.mutex.Unlock()
+	if inRoom {
+		return errInRoom
+	}
+	if !ok {
+		return errNoSuchRoom
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch {
+	case r.closed:
+		return errNoSuchRoom
+	case len(r.members) >= r.settings.MaxPlayers:
+		return errRoomFull
+	case r.status == RoomPlaying:
+		return errMatchRunning
+	}
+
+	rm.mutex.Lock()
+	if rm.byGame[g] != nil {
+		rm.mutex.Unlock()
+		return errInRoom
+	}
+	rm.byGame[g] = r
+	rm.mutex.Unlock()
+
+	r.members = append(r.members, &roomMember{game: g})
+	g.enterRoom(r)
+	g.log.Info("joined room", "room", r.code, "players", len(r.members))
+	r.broadcast()
+	return nil
+}
+
+// leave takes g out of its room. A player leaving mid match loses it. With
+// restore the game goes back to being a game of its own, otherwise it is
+// about to end anyway.
+func (rm *roomManager) leave(g *game, restore bool) error {
+	r := rm.roomOf(g)
+	if r == nil {
+		return errNotInRoom
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(g)
+	if i < 0 {
+		// Left while we were waiting for the room
+		return errNotInRoom
+	}
+	m := r.members[i]
+	r.members = slices.Delete(r.members, i, i+1)
+
+	// Leaving mid match is losing it
+	quit := m.alive && r.status == RoomPlaying
+	if quit {
+		m.alive = false
+		m.place = len(r.alive()) + 1
+		r.quitters = append(r.quitters, m)
+	}
+
+	// Bots do not stay on their own
+	var bots []*game
+	if !slices.ContainsFunc(r.members, func(m *roomMember) bool { return !m.bot }) {
+		for _, m := range r.members {
+			bots = append(bots, m.game)
+		}
+		r.members = nil
+	}
+
+	rm.mutex.Lock()
+	delete(rm.byGame, g)
+	for _, bot := range bots {
+		delete(rm.byGame, bot)
+	}
+	if len(r.members) == 0 {
+		r.closed = true
+		delete(rm.rooms, r.code)
+	}
+	rm.mutex.Unlock()
+
+	for _, bot := range bots {
+		bot.Stop()
+	}
+
+	g.log.Info("left room", "room", r.code, "players", len(r.members))
+	if restore {
+		g.leaveRoom()
+	} else {
+		// Recorded while still in the room so it counts as versus
+		g.do(func() {
+			g.RecordResult()
+			g.room = nil
+		})
+	}
+
+	if r.closed {
+		return nil
+	}
+	if quit {
+		r.finishIfDecided()
+	}
+	r.broadcast()
+	return nil
+}
+
+// ready marks g as ready for the next match or not
+func (rm *roomManager) ready(g *game, ready bool) error {
+	return rm.with(g, func(r *room, m *roomMember) error {
+		if r.status == RoomPlaying {
+			return errMatchRunning
+		}
+		m.ready = ready
+		r.broadcast()
+		return nil
+	})
+}
+
+// configure changes the settings of g's room. Everybody has to ready up
+// again for the new settings.
+func (rm *roomManager) configure(g *game, change RoomSettings) error {
+	return rm.with(g, func(r *room, m *roomMember) error {
+		if r.host() != m {
+			return errNotHost
+		}
+		if r.status == RoomPlaying {
+			return errMatchRunning
+		}
+		settings, err := r.settings.apply(change)
+		if err != nil {
+			return err
+		}
+		if settings.MaxPlayers < len(r.members) {
+			return errTooManyInRoom
+		}
+
+		r.settings = settings
+		for _, m := range r.members {
+			m.ready = false
+		}
+		r.broadcast()
+		return nil
+	})
+}
+
+// start begins a match in g's room. Starting needs every other player to be
+// ready while a restart throws away the match being played and begins a new
+// one right away.
+func (rm *roomManager) start(g *game, restart bool) error {
+	return rm.with(g, func(r *room, m *roomMember) error {
+		if r.host() != m {
+			return errNotHost
+		}
+		if !restart {
+			if r.status == RoomPlaying {
+				return errMatchRunning
+			}
+			for _, other := range r.members {
+				if other != m && !other.ready && !other.bot {
+					return errNotReady
+				}
+			}
+		}
+
+		r.begin()
+		return nil
+	})
+}
+
+// matchmade opens a room for players the matchmaking queue put together and
+// starts their match, with bots in the seats nobody turned up for. Players
+// who went into a room of their own in the meantime are left out.
+func (rm *roomManager) matchmade(games []*game, bots int, rated bool) {
+	r := &room{
+		settings: defaultRoomSettings(),
+		status:   RoomLobby,
+		rated:    rated,
+	}
+	r.settings.MaxPlayers = len(games) + bots
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rm.mutex.Lock()
+	for _, g := range games {
+		if rm.byGame[g] == nil {
+			r.members = append(r.members, &roomMember{game: g})
+		}
+	}
+	if len(r.members) == 0 {
+		rm.mutex.Unlock()
+		return
+	}
+	for range bots {
+		r.members = append(r.members, &roomMember{game: newBot(registry.rules), bot: true})
+	}
+	r.code = rm.newCode()
+	rm.rooms[r.code] = r
+	for _, m := range r.members {
+		rm.byGame[m.game] = r
+	}
+	rm.mutex.Unlock()
+
+	for _, m := range r.members {
+		m.game.enterRoom(r)
+	}
+	r.host().game.log.Info("matchmade room", "room", r.code, "players", len(r.members), "bots", bots)
+	r.begin()
+}
+
+// with runs f on g's room and g's member of it
+func (rm *roomManager) with(g *game, f func(r *room, m *roomMember) error) error {
+	r := rm.roomOf(g)
+	if r == nil {
+		return errNotInRoom
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(g)
+	if i < 0 {
+		return errNotInRoom
+	}
+	return f(r, r.members[i])
+}
+
+// list summarizes every room, rooms still in their lobby first
+func (rm *roomManager) list() []RoomSummary {
+	rm.mutex.Lock()
+	all := make([]*room, 0, len(rm.rooms))
+	for _, r := range rm.rooms {
+		all = append(all, r)
+	}
+	rm.mutex.Unlock()
+
+	summaries := []RoomSummary{}
+	for _, r := range all {
+		r.mutex.Lock()
+		if !r.closed {
+			summaries = append(summaries, r.summary())
+		}
+		r.mutex.Unlock()
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		lobbyI := summaries[i].Status == RoomLobby
+		lobbyJ := summaries[j].Status == RoomLobby
+		if lobbyI != lobbyJ {
+			return lobbyI
+		}
+		return summaries[i].Code < summaries[j].Code
+	})
+	return summaries
+}
+
+// watchable finds a game in a room by its watch id, which is how the bots'
+// games are watched
+func (rm *roomManager) watchable(watchID string) *game {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for g := range rm.byGame {
+		if watchID != "" && g.watchID == watchID {
+			return g
+		}
+	}
+	return nil
+}
+
+// index is where g is in the members, -1 if it is not a member
+func (r *room) index(g *game) int {
+	return slices.IndexFunc(r.members, func(m *roomMember) bool { return m.game == g })
+}
+
+// host is the member in charge of the room, the player who has been in it
+// the longest. Bots are never in charge.
+func (r *room) host() *roomMember {
+	for _, m := range r.members {
+		if !m.bot {
+			return m
+		}
+	}
+	return r.members[0]
+}
+
+// begin starts a new match for everybody in the room
+func (r *room) begin() {
+	r.match++
+	r.quitters = nil
+	r.starters = len(r.members)
+	r.status = RoomPlaying
+	rules := registry.rules
+	rules.Mode = r.settings.Mode
+	seed := time.Now().UnixNano()
+	table := attackTables[r.settings.Garbage]
+	for _, m := range r.members {
+		m.ready = false
+		m.alive = true
+		m.place = 0
+		m.score = 0
+		m.game.startMatch(r, r.match, rules, table, seed)
+	}
+
+	r.host().game.log.Info("match started", "room", r.code, "match", r.match,
+		"mode", rules.Mode, "players", len(r.members), "seed", seed)
+	r.broadcast()
+}
+
+// toppedOut is called by a member's game when its stack tops out in the
+// given match
+func (r *room) toppedOut(g *game, match int, score int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.index(g)
+	if i < 0 || match != r.match || r.status != RoomPlaying || !r.members[i].alive {
+		return
+	}
+
+	m := r.members[i]
+	m.alive = false
+	m.score = score
+	m.place = len(r.alive()) + 1
+	r.finishIfDecided()
+	r.broadcast()
+}
+
+// alive returns the members still playing the match
+func (r *room) alive() []*roomMember {
+	var alive []*roomMember
+	for _, m := range r.members {
+		if m.alive {
+			alive = append(alive, m)
+		}
+	}
+	return alive
+}
+
+// finishIfDecided ends the match once a single player is left standing, or
+// nobody in a match started alone. The winner's game is held where it is.
+func (r *room) finishIfDecided() {
+	alive := r.alive()
+	if len(alive) > 1 || (len(alive) == 1 && r.starters == 1) {
+		return
+	}
+
+	r.status = RoomFinished
+	if len(alive) == 1 {
+		winner := alive[0]
+		winner.place = 1
+		winner.score = winner.game.holdMatch()
+		winner.game.log.Info("won match", "room", r.code, "match", r.match, "score", winner.score)
+	}
+	if r.rated {
+		r.rate()
+	}
+}
+
+// rate updates the ratings of the players by where they finished
+func (r *room) rate() {
+	var standings []*roomMember
+	for _, m := range slices.Concat(r.members, r.quitters) {
+		if !m.bot && m.place > 0 {
+			standings = append(standings, m)
+		}
+	}
+	sort.Slice(standings, func(i, j int) bool { return standings[i].place < standings[j].place })
+
+	var ids []string
+	for _, m := range standings {
+		ids = append(ids, m.game.id)
+	}
+	ratings.update(ids)
+}
+
+// state is the room as member i sees it
+func (r *room) state(players []RoomPlayer, i int) *RoomState {
+	state := &RoomState{
+		Code:     r.code,
+		Status:   r.status,
+		Settings: r.settings,
+		Players:  slices.Clone(players),
+	}
+	state.Players[i].You = true
+	return state
+}
+
+// broadcast sends every member the room as it is now
+func (r *room) broadcast() {
+	players := r.players()
+	for i, m := range r.members {
+		if !m.bot {
+			m.game.notify(RoomMsg, r.state(players, i))
+		}
+	}
+}
+
+// refresh sends the room to g, a member whose player just reconnected
+func (r *room) refresh(g *game) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if i := r.index(g); i >= 0 {
+		g.notify(RoomMsg, r.state(r.players(), i))
+	}
+}
+
+// players describes the members for RoomState
+func (r *room) players() []RoomPlayer {
+	host := r.host()
+	players := make([]RoomPlayer, len(r.members))
+	for i, m := range r.members {
+		p := RoomPlayer{
+			WatchID: m.game.watchID,
+			Host:    m == host,
+			Bot:     m.bot,
+			Ready:   m.ready || m.bot,
+			Alive:   m.alive,
+			Place:   m.place,
+			Score:   m.score,
+		}
+		m.game.do(func() {
+			p.Name = m.game.name
+			if m.alive {
+				p.Score = m.game.state.Score
+			}
+		})
+		players[i] = p
+	}
+	return players
+}
+
+// summary is the room as listed on /api/rooms
+func (r *room) summary() RoomSummary {
+	host := r.host().game
+	var name string
+	host.do(func() { name = host.name })
+
+	return RoomSummary{
+		Code:       r.code,
+		Host:       name,
+		Status:     r.status,
+		Mode:       r.settings.Mode,
+		Garbage:    r.settings.Garbage,
+		Players:    len(r.members),
+		MaxPlayers: r.settings.MaxPlayers,
+	}
+}
+
+// allowInput charges a room message to the player's input rate
+func (g *game) allowInput(c *client) bool {
+	allowed := false
+	g.do(func() { allowed = g.client == c && g.guard.allow(time.Now()) })
+	return allowed
+}
+
+// reject sends c an error unless the game has let go of it
+func (g *game) reject(c *client, notice ErrorNotice) {
+	g.do(func() {
+		if g.client == c {
+			g.sendError(c, notice)
+		}
+	})
+}
+
+// notify sends the player a message without waiting for it to be written,
+// letting go of the connection if that fails
+func (g *game) notify(msgType MessageType, payload any) {
+	g.post(func() {
+		if err := g.SendMessage(msgType, payload); err != nil && g.client != nil {
+			g.release(g.client, err)
+		}
+	})
+}
+
+// enterRoom holds the game still until the room starts a match. Whatever
+// the player was in the middle of is recorded.
+func (g *game) enterRoom(r *room) {
+	g.do(func() {
+		g.RecordResult()
+		g.room = r
+		g.held = true
+	})
+}
+
+// startMatch starts a new game for match with the room's rules, attack
+// table and seed
+func (g *game) startMatch(r *room, match int, rules GameRules, table *AttackTable, seed int64) {
+	g.do(func() {
+		g.RecordResult()
+		g.attackTable = table
+		g.room = r
+		g.match = match
+		g.rules = rules
+		g.mode = rules.Mode
+		g.held = false
+		g.resetWith(seed)
+		g.send()
+	})
+}
+
+// holdMatch stops the winner's game where it is and returns its score
+func (g *game) holdMatch() int {
+	score := 0
+	g.do(func() {
+		g.held = true
+		score = g.state.Score
+	})
+	return score
+}
+
+// leaveRoom turns the game back into one of its own with the server's rules
+func (g *game) leaveRoom() {
+	g.do(func() {
+		g.RecordResult()
+		g.room = nil
+		g.match = 0
+		g.attackTable = nil
+		g.held = false
+		g.rules = registry.rules
+		g.mode = g.rules.Mode
+		if err := g.SendMessage(RoomMsg, (*RoomState)(nil)); err != nil && g.client != nil {
+			g.release(g.client, err)
+		}
+		g.Reset()
+		g.send()
+	})
+}
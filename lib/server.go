@@ -27,53 +27,67 @@
 package gotris
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
-	"github.com/gorilla/websocket"
+	"github.com/matchstick/gotris/static"
 )
 
-type session struct {
-	conn *websocket.Conn
-	id   string
-	game *game
-}
+// scores is where finished games are recorded, nil when running without a
+// leaderboard.
+var scores *Leaderboard
 
-type sessionManager struct {
-	sessions      map[string]*session
-	mutex         sync.RWMutex
-	readySessions chan *session
+// ServerConfig holds everything needed to start a gotris server
+type ServerConfig struct {
+	Bind              string // host, IP or "unix:<path>", empty for all interfaces
+	Port              int
+	NumPlayers        int // how many players fit in a new room, 0 for DefaultRoomPlayers
+	LeaderboardPath   string
+	ReconnectGrace    time.Duration
+	IdleTimeout       time.Duration
+	DuplicateSessions DuplicatePolicy
+	DrainTimeout      time.Duration
+	StaticDir         string // serve the client from here, empty for the built in copy
+	TLSCert           string // certificate and key files, both empty for plain http
+	TLSKey            string
+	AllowedOrigins    []string // browser origins allowed to connect, see checkOrigin
+	MaxSessions       int      // 0 for no cap, like MaxConnsPerIP
+	MaxConnsPerIP     int
+	MaxMessageSize    int64
+	ReadTimeout       time.Duration
+	PingInterval      time.Duration
+	AdminToken        string // bearer token for the /admin endpoints, empty turns them off
+	Rules             GameRules
+	MatchRating       bool          // matchmaking pairs players by rating
+	MatchBotAfter     time.Duration // bots fill a match after this long in the queue, zero never
+	AttackTables      string        // JSON file with more garbage rules, see LoadAttackTables
 }
 
-var registry = sessionManager{
-	sessions:      make(map[string]*session),
-	readySessions: make(chan *session),
-}
+// DefaultDrainTimeout is how long games get to finish when the server stops
+const DefaultDrainTimeout = 30 * time.Second
 
-func registerSession(c *websocket.Conn, id string) {
-	s := &session{
-		conn: c,
-		id:   id,
-		game: MakeNewGame(c, id),
-	}
+const (
+	maxPlayerNameLen       = 32
+	defaultLeaderboardSize = 10
 
-	registry.mutex.Lock()
-	_, exists := registry.sessions[id]
-	if !exists {
-		registry.sessions[id] = s
-	} else {
-		log.Printf("Session registered with same id %s\n", id)
-		os.Exit(1)
-	}
-	registry.mutex.Unlock()
+	unixBindPrefix = "unix:"
 
-	registry.readySessions <- s
-}
+	// shutdownWait bounds how long plain HTTP requests get once the games
+	// have been drained
+	shutdownWait = 5 * time.Second
+)
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Extract session ID from query params or cookie
@@ -82,13 +96,128 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		sessionID = "anonymous-" + r.RemoteAddr // Fallback
 	}
 
+	// Names are cut by runes so the leaderboard never stores half of one
+	name := strings.TrimSpace(strings.ToValidUTF8(r.URL.Query().Get("name"), ""))
+	if name == "" {
+		name = "anonymous"
+	}
+	if utf8.RuneCountInString(name) > maxPlayerNameLen {
+		name = string([]rune(name)[:maxPlayerNameLen])
+	}
+
+	if registry.draining.Load() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip := remoteIP(r)
+	if !limits.perIP.acquire(ip) {
+		slog.Warn("too many connections", "remote", ip)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer limits.perIP.release(ip)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	conn.SetReadLimit(limits.maxMessageSize)
+
+	registry.serve(conn, sessionID, name)
+}
+
+// handleWatch streams a game to a spectator. The game is named by the
+// player's session_id or by the watch id listed on /api/games.
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	g := registry.watchable(query.Get("session_id"), query.Get("game"))
+	if g == nil {
+		http.Error(w, "no such game", http.StatusNotFound)
+		return
+	}
+
+	if registry.draining.Load() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip := remoteIP(r)
+	if !limits.perIP.acquire(ip) {
+		slog.Warn("too many connections", "remote", ip)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer limits.perIP.release(ip)
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Upgrade error:", err)
+		slog.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
 		return
 	}
+	conn.SetReadLimit(limits.maxMessageSize)
 
-	registerSession(conn, sessionID)
+	err = g.Watch(conn)
+	slog.Debug("spectator left", "remote", r.RemoteAddr, "err", err)
+}
+
+// handleGames lists the games that can be watched
+func handleGames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	live := registry.liveGames()
+	if live == nil {
+		live = []LiveGame{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(live); err != nil {
+		slog.Error("failed to encode games", "err", err)
+	}
+}
+
+// handleRooms lists the rooms, the ones that can be joined first
+func handleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rooms.list()); err != nil {
+		slog.Error("failed to encode rooms", "err", err)
+	}
+}
+
+func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultLeaderboardSize
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	top := []ScoreEntry{}
+	if scores != nil {
+		top = scores.Top(GameMode(r.URL.Query().Get("mode")), limit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(top); err != nil {
+		slog.Error("failed to encode leaderboard", "err", err)
+	}
 }
 
 func findFQDN() (string, error) {
@@ -128,32 +257,239 @@ func findFQDN() (string, error) {
 	return hostname, nil
 }
 
-func NewSoloServer(port int) error {
-	// Set up static file server
-	fs := http.FileServer(http.Dir("./static"))
+// listenAddress turns the bind setting and port into something net.Listen
+// understands. A bind of "unix:<path>" listens on a unix socket and ignores
+// the port, anything else is a host name or IP address, empty meaning every
+// interface.
+func listenAddress(bind string, port int) (network string, address string) {
+	if path, ok := strings.CutPrefix(bind, unixBindPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", net.JoinHostPort(bind, strconv.Itoa(port))
+}
+
+// serverURL is what we tell people to point their browser at
+func serverURL(bind string, port int, useTLS bool) (string, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	if path, ok := strings.CutPrefix(bind, unixBindPrefix); ok {
+		return scheme + "+unix://" + path, nil
+	}
+
+	host := bind
+	if ip := net.ParseIP(bind); bind == "" || (ip != nil && ip.IsUnspecified()) {
+		hostname, err := findFQDN()
+		if err != nil {
+			return "", err
+		}
+		host = hostname
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// removeStaleSocket deletes a unix socket left behind by a server that did
+// not exit cleanly. A socket somebody is still listening on is left alone so
+// net.Listen reports it as in use.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+func serve(cfg ServerConfig) error {
+	// Set up static file server, from disk when hacking on the client
+	fs := http.FileServer(http.FS(static.Files))
+	if cfg.StaticDir != "" {
+		fs = http.FileServer(http.Dir(cfg.StaticDir))
+	}
 	http.Handle("/", fs)
 
 	// Handle WebSocket connection
 	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/ws/watch", handleWatch)
+	http.HandleFunc("/api/leaderboard", handleLeaderboard)
+	http.HandleFunc("/api/games", handleGames)
+	http.HandleFunc("/api/rooms", handleRooms)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("GET /admin/sessions", requireAdmin(handleAdminSessions))
+	http.HandleFunc("DELETE /admin/sessions/{id}", requireAdmin(handleAdminKick))
 
-	hostname, err := findFQDN()
+	useTLS := cfg.TLSCert != ""
+	url, err := serverURL(cfg.Bind, cfg.Port, useTLS)
 	if err != nil {
 		return fmt.Errorf("Error %v\n", err)
 	}
 
+	network, address := listenAddress(cfg.Bind, cfg.Port)
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	srv := &http.Server{}
+	go registry.run()
+
 	// Start server
-	fmt.Printf("http://%s:%d\n", hostname, port)
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	fmt.Println(url)
+	slog.Info("listening", "network", network, "address", address, "tls", useTLS)
+	serveErr := make(chan error, 1)
+	go func() {
+		if useTLS {
+			serveErr <- srv.ServeTLS(listener, cfg.TLSCert, cfg.TLSKey)
+			return
+		}
+		serveErr <- srv.Serve(listener)
+	}()
+
+	return serveUntilSignal(srv, serveErr, cfg.DrainTimeout)
+}
+
+// serveUntilSignal waits for the server to fail or for SIGINT/SIGTERM. On a
+// signal the games are drained before the HTTP server is shut down. A second
+// signal during the drain kills the process straight away.
+func serveUntilSignal(srv *http.Server, serveErr <-chan error, drain time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serveErr:
 		return fmt.Errorf("Server error: %w", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "drain_timeout", drain.String())
+	registry.drain(drain)
+	if scores != nil {
+		scores.Flush()
 	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownWait)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("Shutdown error: %w", err)
+	}
+
+	slog.Info("server stopped")
 	return nil
 }
-func NewServer(port int, numPlayers int) error {
-	if numPlayers == 1 {
-		return NewSoloServer(port)
+
+func NewServer(cfg ServerConfig) error {
+	// Check everything before opening files or starting goroutines, so a bad
+	// config leaves nothing behind
+	switch cfg.DuplicateSessions {
+	case "", TakeoverDuplicates, RejectDuplicates:
+	default:
+		return fmt.Errorf("unknown duplicate session policy %q", cfg.DuplicateSessions)
+	}
+	if cfg.MatchBotAfter < 0 {
+		return fmt.Errorf("match bot wait cannot be negative")
+	}
+	if cfg.NumPlayers < 0 || cfg.NumPlayers > maxRoomPlayers {
+		return fmt.Errorf("players must be between 1 and %d, or 0 for the default", maxRoomPlayers)
+	}
+	if cfg.Rules.Mode != "" {
+		if _, err := ParseGameMode(string(cfg.Rules.Mode)); err != nil {
+			return err
+		}
+	}
+	if cfg.Rules.Gravity < 0 || cfg.Rules.LockDelay < 0 {
+		return fmt.Errorf("gravity and lock delay cannot be negative")
+	}
+	if cfg.Rules.InputRate < 0 {
+		return fmt.Errorf("input rate cannot be negative")
+	}
+	if cfg.MaxSessions < 0 || cfg.MaxConnsPerIP < 0 {
+		return fmt.Errorf("session and connection caps cannot be negative")
+	}
+	readTimeout := limits.readTimeout
+	if cfg.ReadTimeout > 0 {
+		readTimeout = cfg.ReadTimeout
+	}
+	if cfg.PingInterval < 0 {
+		return fmt.Errorf("ping interval cannot be negative")
+	}
+	if cfg.PingInterval > 0 && cfg.PingInterval >= readTimeout {
+		return fmt.Errorf("ping interval %s must be shorter than the read timeout %s",
+			cfg.PingInterval, readTimeout)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key")
+	}
+	if cfg.TLSCert != "" {
+		// Fail now rather than after the server claims to be up
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	}
+	if cfg.AttackTables != "" {
+		if err := LoadAttackTables(cfg.AttackTables); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Multi Player not set up yet....\n")
-	return nil
+	if cfg.LeaderboardPath != "" {
+		lb, err := OpenLeaderboard(cfg.LeaderboardPath)
+		if err != nil {
+			return err
+		}
+		scores = lb
+	}
+
+	if cfg.ReconnectGrace > 0 {
+		registry.grace = cfg.ReconnectGrace
+	}
+	if cfg.IdleTimeout > 0 {
+		registry.idleTimeout = cfg.IdleTimeout
+	}
+	if cfg.DuplicateSessions != "" {
+		registry.duplicates = cfg.DuplicateSessions
+	}
+	if cfg.NumPlayers > 0 {
+		registry.roomPlayers = cfg.NumPlayers
+	}
+	if cfg.Rules.Mode != "" {
+		registry.rules.Mode = cfg.Rules.Mode
+	}
+	if cfg.Rules.Gravity > 0 {
+		registry.rules.Gravity = cfg.Rules.Gravity
+	}
+	registry.rules.LockDelay = cfg.Rules.LockDelay
+	registry.rules.InputRate = cfg.Rules.InputRate
+	registry.matchmaker.rated = cfg.MatchRating
+	registry.matchmaker.botAfter = cfg.MatchBotAfter
+
+	limits.allowedOrigins = cfg.AllowedOrigins
+	limits.maxSessions = cfg.MaxSessions
+	limits.perIP = newIPLimiter(cfg.MaxConnsPerIP)
+	if cfg.MaxMessageSize > 0 {
+		limits.maxMessageSize = cfg.MaxMessageSize
+	}
+	limits.readTimeout = readTimeout
+	limits.pingInterval = cfg.PingInterval
+
+	adminToken = cfg.AdminToken
+
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = DefaultDrainTimeout
+	}
+
+	return serve(cfg)
 }
@@ -0,0 +1,486 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DuplicatePolicy decides what happens when a client connects with the id
+// of a session that still has a live connection.
+type DuplicatePolicy string
+
+const (
+	// TakeoverDuplicates moves the game to the new connection and closes the
+	// old one. This is what a reconnecting client needs when the server has
+	// not noticed yet that its old connection is dead.
+	TakeoverDuplicates DuplicatePolicy = "takeover"
+
+	// RejectDuplicates refuses the new connection and leaves the game alone
+	RejectDuplicates DuplicatePolicy = "reject"
+)
+
+// Session timeouts used when the server config leaves them unset
+const (
+	DefaultReconnectGrace = 60 * time.Second
+	DefaultIdleTimeout    = 5 * time.Minute
+
+	idleCheckInterval = 10 * time.Second
+	closeWriteWait    = time.Second
+	drainPollInterval = 250 * time.Millisecond
+)
+
+// Websocket close codes the server uses when it ends a connection on purpose.
+// They are in the range reserved for applications and the close reason is
+// meant to be shown to the player. Clients should not reconnect on their own
+// after receiving one of these.
+const (
+	closeSessionTakenOver = 4000 + iota
+	closeDuplicateSession
+	closeIdleTimeout
+	closeServerShutdown
+	closeServerFull
+	closeKicked
+	closeUnsupportedProtocol
+	closeGameEnded
+)
+
+var (
+	errDuplicateSession = errors.New("session is already connected")
+	errServerFull       = errors.New("too many sessions")
+	errTooManyFromIP    = errors.New("too many sessions from one address")
+)
+
+type session struct {
+	conn    *websocket.Conn
+	id      string
+	game    *game
+	created time.Time
+	remote  string      // address of the latest connection
+	ip      string      // address of the connection that created it, see perIP
+	grace   *time.Timer // running while the session waits for a reconnect
+	ended   bool        // set when the session must not wait for a reconnect
+
+	// replaced is set while a new connection takes over from the current one
+	replaced bool
+
+	// detached is closed once the current connection lets go of the game
+	detached chan struct{}
+}
+
+type sessionManager struct {
+	sessions map[string]*session
+	mutex    sync.RWMutex
+
+	// perIP counts the sessions created from each address, including the
+	// ones waiting for a reconnect, so one address cannot fill the server
+	// by connecting over and over with new ids
+	perIP map[string]int
+
+	// readySessions and leftQueue are how players join and leave the
+	// matchmaking queue, which belongs to run
+	readySessions chan *matchTicket
+	leftQueue     chan *game
+	matchmaker    matchmaker
+
+	grace       time.Duration
+	idleTimeout time.Duration
+	duplicates  DuplicatePolicy
+	rules       GameRules
+	roomPlayers int // max players of a new room
+
+	// draining is set once the server is shutting down and must not take
+	// new connections
+	draining atomic.Bool
+}
+
+var registry = sessionManager{
+	sessions:      make(map[string]*session),
+	perIP:         make(map[string]int),
+	readySessions: make(chan *matchTicket),
+	leftQueue:     make(chan *game),
+	matchmaker:    matchmaker{botAfter: DefaultMatchBotAfter},
+	roomPlayers:   DefaultRoomPlayers,
+	grace:         DefaultReconnectGrace,
+	idleTimeout:   DefaultIdleTimeout,
+	duplicates:    TakeoverDuplicates,
+	rules: GameRules{
+		Mode:      Marathon,
+		Gravity:   DefaultGravity,
+		LockDelay: DefaultLockDelay,
+		InputRate: DefaultInputRate,
+	},
+}
+
+// closeWithReason tells the client why it is being disconnected and closes
+// the connection. WriteControl is safe to call alongside the game's writes.
+func closeWithReason(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait))
+	if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		slog.Debug("failed to send close message", "err", err)
+	}
+	conn.Close()
+}
+
+// run keeps the matchmaking queue, pairing up the players in it, and reaps
+// idle connections
+func (m *sessionManager) run() {
+	idleTicker := time.NewTicker(idleCheckInterval)
+	defer idleTicker.Stop()
+	matchTicker := time.NewTicker(matchInterval)
+	defer matchTicker.Stop()
+
+	for {
+		select {
+		case t := <-m.readySessions:
+			m.matchmaker.add(t)
+		case g := <-m.leftQueue:
+			m.matchmaker.remove(g)
+		case now := <-matchTicker.C:
+			m.matchmaker.pair(now)
+		case <-idleTicker.C:
+			m.reapIdle()
+		}
+	}
+}
+
+// serve runs a connection for its whole life: it attaches it to its session,
+// plays the game until the connection drops and then either waits for a
+// reconnect or ends the session.
+func (m *sessionManager) serve(conn *websocket.Conn, id string, name string) {
+	log := slog.With("session", id, "remote", conn.RemoteAddr().String())
+
+	if m.draining.Load() {
+		closeWithReason(conn, closeServerShutdown, "Server is shutting down")
+		return
+	}
+
+	s, err := m.register(conn, id, name, log)
+	if err != nil {
+		log.Warn("connection rejected", "err", err)
+		if errors.Is(err, errServerFull) {
+			closeWithReason(conn, closeServerFull, "Server is full, try again later")
+		} else if errors.Is(err, errTooManyFromIP) {
+			closeWithReason(conn, closeServerFull, "Too many games from your address")
+		} else {
+			closeWithReason(conn, closeDuplicateSession, "This game is already open in another window")
+		}
+		return
+	}
+
+	err = m.play(s, conn)
+	m.release(s, err)
+}
+
+// play runs the session's game on conn and turns a panic into an error, so
+// whatever goes wrong with one player only ends that player's session.
+func (m *sessionManager) play(s *session, conn *websocket.Conn) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			conn.Close()
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return s.game.Attach(conn)
+}
+
+// register attaches conn to the session with the given id, creating the
+// session and its game if the id is new. Depending on the duplicate policy a
+// session that is still connected is either taken over or the new connection
+// is refused with errDuplicateSession.
+func (m *sessionManager) register(conn *websocket.Conn, id string, name string, log *slog.Logger) (*session, error) {
+	m.mutex.Lock()
+	s, exists := m.sessions[id]
+	for exists && s.conn != nil {
+		if m.duplicates == RejectDuplicates {
+			m.mutex.Unlock()
+			return nil, errDuplicateSession
+		}
+
+		// Close the old connection and wait for it to let go of the game
+		old, detached := s.conn, s.detached
+		s.replaced = true
+		m.mutex.Unlock()
+
+		log.Info("session taken over")
+		closeWithReason(old, closeSessionTakenOver, "This game was opened in another window")
+		<-detached
+
+		m.mutex.Lock()
+		s, exists = m.sessions[id]
+	}
+
+	if exists {
+		if s.grace != nil {
+			s.grace.Stop()
+			s.grace = nil
+		}
+		s.game.SetName(name)
+		log.Info("reconnected", "player", name)
+	} else {
+		if limits.maxSessions > 0 && len(m.sessions) >= limits.maxSessions {
+			m.mutex.Unlock()
+			return nil, errServerFull
+		}
+		ip := addrIP(conn.RemoteAddr().String())
+		if limits.perIP.max > 0 && m.perIP[ip] >= limits.perIP.max {
+			m.mutex.Unlock()
+			return nil, errTooManyFromIP
+		}
+
+		s = &session{
+			id:      id,
+			game:    MakeNewGame(id, name, m.rules, log),
+			created: time.Now(),
+			ip:      ip,
+		}
+		m.sessions[id] = s
+		m.perIP[ip]++
+		log.Info("connected", "player", name)
+	}
+	s.conn = conn
+	s.remote = conn.RemoteAddr().String()
+	s.detached = make(chan struct{})
+	m.mutex.Unlock()
+
+	return s, nil
+}
+
+// release is called once a session's connection is gone. Finished games,
+// failed sessions and sessions that were ended on purpose are dropped right
+// away, anything else waits for the client to come back.
+func (m *sessionManager) release(s *session, err error) {
+	over := s.game.IsOver()
+
+	m.mutex.Lock()
+	s.conn = nil
+	close(s.detached)
+
+	if s.replaced {
+		// Errors from closing the old connection do not count, the
+		// connection taking over attaches next
+		s.replaced = false
+		m.mutex.Unlock()
+		return
+	}
+
+	switch {
+	case err == nil, errors.Is(err, errGameStopped):
+	case errors.Is(err, errConnLost):
+		// Nothing wrong with the session, wait for the client as usual
+		s.game.log.Info("connection lost", "err", err)
+	default:
+		s.game.log.Error("session failed, ending it", "err", err)
+		s.ended = true
+	}
+
+	if s.ended || over {
+		m.mutex.Unlock()
+		m.unregister(s)
+		return
+	}
+
+	s.grace = time.AfterFunc(m.grace, func() { m.expire(s) })
+	m.mutex.Unlock()
+}
+
+// unregister removes the session and ends its game
+func (m *sessionManager) unregister(s *session) {
+	m.mutex.Lock()
+	if m.sessions[s.id] != s {
+		m.mutex.Unlock()
+		return
+	}
+	delete(m.sessions, s.id)
+	m.perIP[s.ip]--
+	if m.perIP[s.ip] <= 0 {
+		delete(m.perIP, s.ip)
+	}
+	m.mutex.Unlock()
+
+	s.game.log.Info("session ended")
+	m.dequeue(s.game)
+	rooms.leave(s.game, false)
+	s.game.Stop()
+}
+
+// expire drops a session whose client never came back
+func (m *sessionManager) expire(s *session) {
+	m.mutex.RLock()
+	reconnected := s.conn != nil
+	m.mutex.RUnlock()
+
+	if reconnected {
+		// Reconnected while the timer was firing
+		return
+	}
+
+	s.game.log.Info("did not reconnect in time", "grace", m.grace.String())
+	m.unregister(s)
+}
+
+// reapIdle disconnects sessions that have not sent anything for too long.
+// Their sessions end instead of waiting for a reconnect. Players in a room or
+// in the matchmaking queue are waiting on others, not idle.
+func (m *sessionManager) reapIdle() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, s := range m.sessions {
+		if s.conn == nil || s.game.IdleFor() < m.idleTimeout {
+			continue
+		}
+		if m.matchmaker.has(s.game) || rooms.roomOf(s.game) != nil {
+			continue
+		}
+
+		s.game.log.Info("idle, disconnecting", "idle_timeout", m.idleTimeout.String())
+		s.ended = true
+		go closeWithReason(s.conn, closeIdleTimeout, "Disconnected for inactivity")
+	}
+}
+
+// kick ends the session with the given id, disconnecting its client if it
+// has one. It returns false if there is no such session.
+func (m *sessionManager) kick(id string) bool {
+	m.mutex.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mutex.Unlock()
+		return false
+	}
+
+	s.ended = true
+	if s.conn != nil {
+		// The session is dropped once its connection lets go, see release
+		conn := s.conn
+		m.mutex.Unlock()
+		closeWithReason(conn, closeKicked, "Disconnected by the server admin")
+		return true
+	}
+	if s.grace != nil {
+		s.grace.Stop()
+	}
+	m.mutex.Unlock()
+
+	m.unregister(s)
+	return true
+}
+
+// drain shuts every session down. Players are told the server is going away
+// and get up to timeout to finish their games, then whatever is left is
+// disconnected and its game recorded.
+func (m *sessionManager) drain(timeout time.Duration) {
+	m.draining.Store(true)
+
+	for _, s := range m.snapshot() {
+		s.game.NotifyShutdown(timeout)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && m.playing() > 0 {
+		time.Sleep(drainPollInterval)
+	}
+
+	sessions := m.snapshot()
+	slog.Info("ending sessions", "count", len(sessions))
+
+	// Say goodbye before the games let go of their connections
+	var wg sync.WaitGroup
+	m.mutex.Lock()
+	for _, s := range sessions {
+		s.ended = true
+		if s.conn != nil {
+			wg.Add(1)
+			go func(conn *websocket.Conn) {
+				defer wg.Done()
+				closeWithReason(conn, closeServerShutdown, "Server is shutting down")
+			}(s.conn)
+		}
+	}
+	m.mutex.Unlock()
+	wg.Wait()
+
+	for _, s := range sessions {
+		m.unregister(s)
+	}
+}
+
+// snapshot returns the sessions registered right now
+func (m *sessionManager) snapshot() []*session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sessions := make([]*session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// playing counts the connected sessions whose game is still going
+func (m *sessionManager) playing() int {
+	var connected []*session
+	m.mutex.RLock()
+	for _, s := range m.sessions {
+		if s.conn != nil {
+			connected = append(connected, s)
+		}
+	}
+	m.mutex.RUnlock()
+
+	n := 0
+	for _, s := range connected {
+		if s.game.Playing() {
+			n++
+		}
+	}
+	return n
+}
+
+// counts reports how many sessions are registered and how many of them have
+// a client connected
+func (m *sessionManager) counts() (registered int, connected int) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, s := range m.sessions {
+		if s.conn != nil {
+			connected++
+		}
+	}
+	return len(m.sessions), connected
+}
@@ -0,0 +1,247 @@
+// This code was generated with assistance from Claude AI by Anthropic.
+// It is provided under the MIT License, which allows for free use, modification,
+// and distribution with proper attribution.
+//
+// MIT License
+//
+// Copyright (c) [2025] [Michael Rubin]
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gotris
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Spectators watch a game over the same protocol as its player, hello and
+// resync included, but cannot play. The game goroutine does not write to
+// them itself: their frames are queued for a writer goroutine per spectator,
+// so a spectator on a slow link can never hold up the game. One that falls
+// too far behind is dropped.
+
+// watcherQueueLen is how many frames a spectator may fall behind
+const watcherQueueLen = 64
+
+// ErrReadOnly is sent to spectators that try to play
+const ErrReadOnly ErrorCode = "read_only"
+
+var errWatcherBehind = errors.New("spectator fell behind")
+
+// outFrame is a frame waiting for a spectator's writer
+type outFrame struct {
+	msgType   MessageType
+	frameType int
+	data      []byte
+}
+
+// enqueue hands a frame to the spectator's writer
+func (c *client) enqueue(f outFrame) error {
+	select {
+	case c.queue <- f:
+		return nil
+	default:
+		return errWatcherBehind
+	}
+}
+
+// writeLoop writes the spectator's frames and pings it until the game closes
+// its queue. A failed write closes the connection, which makes its reader
+// tell the game it is gone.
+func (c *client) writeLoop() {
+	var pingC <-chan time.Time
+	if limits.pingInterval > 0 {
+		pingTicker := time.NewTicker(limits.pingInterval)
+		defer pingTicker.Stop()
+		pingC = pingTicker.C
+	}
+
+	for {
+		var err error
+		select {
+		case f, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err = c.conn.WriteMessage(f.frameType, f.data)
+			if err == nil {
+				metrics.messagesSent.inc(string(f.msgType))
+			}
+		case <-pingC:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+		}
+
+		if err != nil {
+			metrics.sendErrors.inc("")
+			c.log.Info("spectator write failed", "err", err)
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// Watch streams the game to a spectator until the connection or the game
+// goes away
+func (g *game) Watch(conn *websocket.Conn) error {
+	c := &client{
+		conn:     conn,
+		log:      slog.With("session", g.id, "remote", conn.RemoteAddr().String(), "spectator", true),
+		released: make(chan struct{}),
+		queue:    make(chan outFrame, watcherQueueLen),
+	}
+	defer conn.Close()
+
+	heartbeat(conn)
+	go c.writeLoop()
+
+	ok := g.do(func() {
+		g.watchers[c] = struct{}{}
+		g.watcherCount.Store(int32(len(g.watchers)))
+		g.update(c, nil)
+	})
+	if !ok {
+		close(c.queue)
+		return errGameStopped
+	}
+
+	c.log.Info("spectator joined")
+	return g.read(c)
+}
+
+// isWatching reports whether c is one of the game's spectators
+func (g *game) isWatching(c *client) bool {
+	_, ok := g.watchers[c]
+	return ok
+}
+
+// handleWatcher answers a message from a spectator. They can negotiate the
+// protocol and resync like a player but nothing else.
+func (g *game) handleWatcher(in clientInput) {
+	if in.err != nil {
+		g.sendError(in.from, ErrorNotice{Code: ErrMalformed, Message: in.err.Error()})
+		return
+	}
+
+	switch in.msg.Type {
+	case Hello:
+		g.hello(in.from, in.msg)
+	case Resync:
+		g.resync(in.from)
+	case Move, NewGame:
+		g.sendError(in.from, ErrorNotice{
+			Code:    ErrReadOnly,
+			Type:    in.msg.Type,
+			Message: "spectators cannot play",
+		})
+	default:
+		if isLobbyMessage(in.msg.Type) {
+			g.sendError(in.from, ErrorNotice{
+				Code:    ErrReadOnly,
+				Type:    in.msg.Type,
+				Message: "spectators cannot join matches",
+			})
+			return
+		}
+		g.sendError(in.from, ErrorNotice{
+			Code:    ErrUnknownType,
+			Type:    in.msg.Type,
+			Message: fmt.Sprintf("unknown message type %q", in.msg.Type),
+		})
+	}
+}
+
+// LiveGame describes a game that can be watched on /api/games
+type LiveGame struct {
+	WatchID  string   `json:"watch_id"`
+	Player   string   `json:"player"`
+	Mode     GameMode `json:"mode"`
+	Score    int      `json:"score"`
+	Level    int      `json:"level"`
+	Lines    int      `json:"lines"`
+	Watchers int      `json:"watchers"`
+}
+
+// newWatchID makes the public id a game is watched by. Session ids are not
+// shown to other players since knowing one is enough to take the game over.
+func newWatchID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// watchable finds the game to watch by session id or watch id, nil if there
+// is none
+func (m *sessionManager) watchable(sessionID string, watchID string) *game {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if s, ok := m.sessions[sessionID]; ok && sessionID != "" {
+		return s.game
+	}
+	for _, s := range m.sessions {
+		if watchID != "" && s.game.watchID == watchID {
+			return s.game
+		}
+	}
+	return rooms.watchable(watchID)
+}
+
+// liveGames lists the games being played right now, best score first
+func (m *sessionManager) liveGames() []LiveGame {
+	var live []LiveGame
+	for _, s := range m.snapshot() {
+		m.mutex.RLock()
+		connected := s.conn != nil
+		m.mutex.RUnlock()
+		if !connected {
+			continue
+		}
+
+		var lg LiveGame
+		over := true
+		s.game.do(func() {
+			over = s.game.state.GameOver || s.game.held
+			lg = LiveGame{
+				Player: s.game.name,
+				Mode:   s.game.mode,
+				Score:  s.game.state.Score,
+				Level:  s.game.state.Level,
+				Lines:  s.game.state.LinesCleared,
+			}
+		})
+		if over {
+			continue
+		}
+		lg.WatchID = s.game.watchID
+		lg.Watchers = int(s.game.watcherCount.Load())
+		live = append(live, lg)
+	}
+
+	sort.Slice(live, func(i, j int) bool { return live[i].Score > live[j].Score })
+	return live
+}
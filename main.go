@@ -27,7 +27,7 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/matchstick/gotris/cmd"
@@ -35,8 +35,8 @@ import (
 
 func main() {
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(os.Stdout)
+	// Commands that serve pick their own format and level, see setupLogging
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
 
 	cmd.Execute()
 }